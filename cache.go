@@ -0,0 +1,132 @@
+package tql
+
+import (
+	"container/list"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// parseCacheSize is the maximum number of parse results kept by the global parse cache
+const parseCacheSize = 1024
+
+// globalParseCache holds the parse results shared by the query templates created with WithParseCache
+var globalParseCache = newParseCache(parseCacheSize)
+
+// parseCacheKey identifies a parse result, the options that change the result of parse are part of the key
+type parseCacheKey struct {
+	reflectedType reflect.Type
+	sql           string
+	tagNames      string
+	noRewrite     bool
+	positional    bool
+}
+
+// parseCacheEntry is a cached parse result
+type parseCacheEntry struct {
+	key     parseCacheKey
+	sql     string
+	indices [][]int
+}
+
+// parseCache is a bounded, least recently used cache of parse results that is safe for concurrent use
+type parseCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[parseCacheKey]*list.Element
+	order    *list.List
+}
+
+// newParseCache creates a parse cache that holds at most capacity results
+//
+// Parameters:
+//   - capacity: The maximum number of results
+//
+// Returns:
+//   - *parseCache: The empty cache
+func newParseCache(capacity int) *parseCache {
+	return &parseCache{capacity: capacity, entries: map[parseCacheKey]*list.Element{}, order: list.New()}
+}
+
+// newParseCacheKey creates the key of the parse result of the SQL for the type T
+//
+// Parameters:
+//   - sql: The generated SQL
+//   - opts: The options of the query
+//
+// Returns:
+//   - parseCacheKey: The key
+func newParseCacheKey[T any](sql string, opts options) parseCacheKey {
+	return parseCacheKey{
+		reflectedType: reflect.TypeFor[T](),
+		sql:           sql,
+		tagNames:      strings.Join(opts.tagNames, ","),
+		noRewrite:     opts.noRewrite,
+		positional:    opts.positionalScan,
+	}
+}
+
+// get returns the cached parse result and marks it as recently used.
+// The indices are shared between the callers and must not be modified.
+//
+// Parameters:
+//   - key: The key of the parse result
+//
+// Returns:
+//   - string: The parsed SQL
+//   - [][]int: The indices of the selected fields
+//   - bool: True if the result was cached, false otherwise
+func (cache *parseCache) get(key parseCacheKey) (string, [][]int, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	element, ok := cache.entries[key]
+	if !ok {
+		return "", nil, false
+	}
+	cache.order.MoveToFront(element)
+	entry := element.Value.(*parseCacheEntry)
+	return entry.sql, entry.indices, true
+}
+
+// put caches a parse result, evicting the least recently used result when the cache is full
+//
+// Parameters:
+//   - key: The key of the parse result
+//   - sql: The parsed SQL
+//   - indices: The indices of the selected fields
+func (cache *parseCache) put(key parseCacheKey, sql string, indices [][]int) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if element, ok := cache.entries[key]; ok {
+		cache.order.MoveToFront(element)
+		element.Value = &parseCacheEntry{key: key, sql: sql, indices: indices}
+		return
+	}
+	cache.entries[key] = cache.order.PushFront(&parseCacheEntry{key: key, sql: sql, indices: indices})
+	if cache.order.Len() > cache.capacity {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*parseCacheEntry).key)
+	}
+}
+
+// len returns the number of cached results
+func (cache *parseCache) len() int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.order.Len()
+}
+
+// clear removes all the cached results
+func (cache *parseCache) clear() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.entries = map[parseCacheKey]*list.Element{}
+	cache.order.Init()
+}
+
+// ClearParseCache removes all the parse results cached by the query templates created with WithParseCache.
+// This is mostly useful in tests.
+func ClearParseCache() {
+	globalParseCache.clear()
+}
@@ -0,0 +1,164 @@
+package tql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go/format"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// integerTypes are the database types of integer columns, including the postgres aliases and serial types
+var integerTypes = []string{
+	"INT", "INTEGER", "BIGINT", "SMALLINT", "TINYINT", "MEDIUMINT", "INT2", "INT4", "INT8",
+	"SERIAL", "BIGSERIAL", "SMALLSERIAL", "SERIAL2", "SERIAL4", "SERIAL8",
+}
+
+// GenerateStruct runs the query and generates the Go source of a struct with a tql tagged field per column.
+// The field types are derived from the database types of the columns and use the sql.Null* types for nullable columns.
+// The struct is named after the first table in the FROM or JOIN clause of the query, or Result if there is none, and
+// the fields are in column order, so the output is deterministic. A column name repeated by a join gets a numbered
+// field, e.g. Id2. Only the struct is generated, the file it is written to needs a package clause and the database/sql
+// and time imports its field types use. This is a development tool, e.g. for go:generate, not a runtime path.
+//
+// Example usage:
+//
+//	source, err := GenerateStruct(ctx, db, "SELECT * FROM User")
+//	// type User struct {
+//	// 	Id   int64          `tql:"id"`
+//	// 	Name sql.NullString `tql:"name"`
+//	// }
+//
+// Parameters:
+//   - ctx: The context for the query execution
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - query: The query to generate the struct for, its placeholders are bound to args
+//   - args: Optional arguments of the query
+//
+// Returns:
+//   - string: The gofmt formatted source of the struct
+//   - error: If the query fails or the source can't be formatted
+func GenerateStruct(ctx context.Context, db Preparer, query string, args ...any) (string, error) {
+	if isNil(db) {
+		log.ErrorContext(ctx, "GenerateStruct called with a nil tx or db")
+		return "", errors.Join(ErrExecutingQuery, ErrPreparingQuery)
+	}
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to prepare query", "error", err)
+		return "", errors.Join(ErrPreparingQuery, err)
+	}
+	defer stmt.Close()
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return "", errors.Join(ErrExecutingQuery, err)
+	}
+	defer rows.Close()
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return "", errors.Join(ErrExecutingQuery, err)
+	}
+	name := "Result"
+	if match := tableRegex.FindStringSubmatch(query); match != nil {
+		name = goName(match[1])
+	}
+	var source strings.Builder
+	fmt.Fprintf(&source, "type %s struct {\n", name)
+	fieldNames := map[string]int{}
+	for _, columnType := range columnTypes {
+		fieldName := goName(columnType.Name())
+		// a column name repeated by a join gets a numbered field
+		if count := fieldNames[fieldName]; count > 0 {
+			fieldNames[fieldName]++
+			fieldName = fmt.Sprintf("%s%d", fieldName, count+1)
+		} else {
+			fieldNames[fieldName] = 1
+		}
+		fmt.Fprintf(&source, "%s %s `tql:%q`\n", fieldName, goType(columnType), columnType.Name())
+	}
+	source.WriteString("}\n")
+	formatted, err := format.Source([]byte(source.String()))
+	if err != nil {
+		log.ErrorContext(ctx, "failed to format the generated struct", "error", err)
+		return "", err
+	}
+	return string(formatted), nil
+}
+
+// goName converts a table or column name to an exported Go identifier, e.g. created_at becomes CreatedAt
+//
+// Parameters:
+//   - name: The table or column name
+//
+// Returns:
+//   - string: The exported Go identifier
+func goName(name string) string {
+	var goName strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(char rune) bool {
+		return !unicode.IsLetter(char) && !unicode.IsDigit(char)
+	}) {
+		runes := []rune(part)
+		goName.WriteString(string(unicode.ToUpper(runes[0])) + string(runes[1:]))
+	}
+	if goName.Len() == 0 || unicode.IsDigit([]rune(goName.String())[0]) {
+		return "Column" + goName.String()
+	}
+	return goName.String()
+}
+
+// goType returns the Go type of a column, a nullable column is a sql.Null* type and a column whose nullability
+// is unknown is considered nullable
+//
+// Parameters:
+//   - columnType: The type of the column reported by the driver
+//
+// Returns:
+//   - string: The Go type
+func goType(columnType *sql.ColumnType) string {
+	nullable, ok := columnType.Nullable()
+	return databaseGoType(columnType.DatabaseTypeName(), nullable || !ok)
+}
+
+// databaseGoType returns the Go type of a database type, see goType. The type is matched by its name without its
+// size or modifiers, e.g. BIGINT for BIGINT(20) UNSIGNED, so POINT or INTERVAL are not mistaken for integers.
+// A DECIMAL or NUMERIC column is a string since a float64 would round its exact value.
+//
+// Parameters:
+//   - databaseType: The database type name reported by the driver
+//   - nullable: Whether the column is nullable
+//
+// Returns:
+//   - string: The Go type
+func databaseGoType(databaseType string, nullable bool) string {
+	databaseType = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(databaseType)), "UNSIGNED ")
+	if end := strings.IndexAny(databaseType, "( "); end >= 0 {
+		databaseType = databaseType[:end]
+	}
+	switch {
+	case strings.HasPrefix(databaseType, "BOOL"):
+		return nullableType("bool", "sql.NullBool", nullable)
+	case slices.Contains(integerTypes, databaseType):
+		return nullableType("int64", "sql.NullInt64", nullable)
+	case databaseType == "DECIMAL" || databaseType == "NUMERIC" || databaseType == "DEC":
+		return nullableType("string", "sql.NullString", nullable)
+	case strings.HasPrefix(databaseType, "FLOAT") || databaseType == "DOUBLE" || databaseType == "REAL":
+		return nullableType("float64", "sql.NullFloat64", nullable)
+	case strings.HasPrefix(databaseType, "DATE") || strings.HasPrefix(databaseType, "TIMESTAMP"):
+		return nullableType("time.Time", "sql.NullTime", nullable)
+	case strings.HasSuffix(databaseType, "BLOB") || strings.HasSuffix(databaseType, "BINARY") || databaseType == "BYTEA":
+		// a nil slice already holds a NULL
+		return "[]byte"
+	}
+	return nullableType("string", "sql.NullString", nullable)
+}
+
+// nullableType returns the nullable type of a column if it is nullable, the type otherwise
+func nullableType(goType string, nullType string, nullable bool) string {
+	if nullable {
+		return nullType
+	}
+	return goType
+}
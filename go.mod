@@ -2,6 +2,10 @@ module github.com/runpod/go-tql
 
 go 1.23.4
 
-require github.com/go-sql-driver/mysql v1.8.1
+require (
+	github.com/go-sql-driver/mysql v1.8.1
+	go.opentelemetry.io/otel v1.31.0
+	go.opentelemetry.io/otel/trace v1.31.0
+)
 
 require filippo.io/edwards25519 v1.1.0 // indirect
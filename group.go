@@ -0,0 +1,85 @@
+package tql
+
+import (
+	"context"
+	"errors"
+)
+
+// Grouping describes how consecutive rows of type T that share a key are collapsed into a parent of type P
+type Grouping[T any, P any, K comparable] struct {
+	// Key returns the key of the parent the row belongs to
+	Key func(row T) K
+	// Parent creates the parent from the first row with a new key
+	Parent func(row T) P
+	// Append adds the child of the row to the parent, it is called for every row including the first one
+	Append func(parent *P, row T)
+}
+
+// QueryGrouped executes a QueryTemplate and groups consecutive rows that share the same key into a single parent.
+// This is useful for one-to-many joins where the parent columns are repeated for every child, the query must be
+// ordered by the parent key for the rows of a parent to be consecutive.
+//
+// Example usage:
+//
+//	type Row struct {
+//	    User    User
+//	    Account Account
+//	}
+//	type UserAccounts struct {
+//	    User
+//	    Accounts []Account
+//	}
+//	query := Must[Row]("SELECT User.*, Account.* FROM User JOIN Account ON User.id = Account.userId ORDER BY User.id")
+//	users, err := QueryGrouped(query, db, Grouping[Row, UserAccounts, int]{
+//	    Key:    func(row Row) int { return row.User.Id },
+//	    Parent: func(row Row) UserAccounts { return UserAccounts{User: row.User} },
+//	    Append: func(user *UserAccounts, row Row) { user.Accounts = append(user.Accounts, row.Account) },
+//	})
+//
+// Parameters:
+//   - query: The QueryTemplate to execute. Must not be nil.
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - grouping: The functions used to group the rows, all of them must be set
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - []P: The parents in the order their first row was returned
+//   - error: If query preparation or execution fails
+func QueryGrouped[T any, P any, K comparable, Q Preparer](query *QueryTemplate[T], db Q, grouping Grouping[T, P, K], data ...any) ([]P, error) {
+	return QueryGroupedContext(query, context.Background(), db, grouping, data...)
+}
+
+// QueryGroupedContext executes a QueryTemplate with the given context and groups consecutive rows that share the same key
+// into a single parent. See QueryGrouped for more details.
+//
+// Parameters:
+//   - query: The QueryTemplate to execute. Must not be nil.
+//   - ctx: The context for the query execution. Used for cancellation and timeouts.
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - grouping: The functions used to group the rows, all of them must be set
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - []P: The parents in the order their first row was returned
+//   - error: If query preparation or execution fails
+func QueryGroupedContext[T any, P any, K comparable, Q Preparer](query *QueryTemplate[T], ctx context.Context, db Q, grouping Grouping[T, P, K], data ...any) ([]P, error) {
+	parents := []P{}
+	if grouping.Key == nil || grouping.Parent == nil || grouping.Append == nil {
+		log.ErrorContext(ctx, "QueryGrouped called with an incomplete grouping")
+		return parents, errors.Join(ErrExecutingQuery, errors.New("grouping requires Key, Parent and Append"))
+	}
+	rows, err := QueryContext(query, ctx, db, data...)
+	if err != nil {
+		return parents, err
+	}
+	var lastKey K
+	for i, row := range rows {
+		key := grouping.Key(row)
+		if i == 0 || key != lastKey {
+			parents = append(parents, grouping.Parent(row))
+			lastKey = key
+		}
+		grouping.Append(&parents[len(parents)-1], row)
+	}
+	return parents, nil
+}
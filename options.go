@@ -0,0 +1,488 @@
+package tql
+
+import (
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+)
+
+var (
+	// defaultTagNames are the struct tags read for column names when no tag names are configured
+	defaultTagNames = []string{"tql", "db"}
+
+	// defaultFunctionsMu guards defaultFunctions against concurrent registrations
+	defaultFunctionsMu sync.RWMutex
+
+	// reservedFunctions are the functions bound by every query generation, which always override functions of the
+	// same name
+	reservedFunctions = []string{"param", "paramAt", "named", "lit", "ident", "tql"}
+)
+
+// Dialect is the SQL dialect the param placeholders are generated for
+type Dialect int
+
+const (
+	// DialectMySQL binds every param with a ? placeholder, it is the default and also works for SQLite
+	DialectMySQL Dialect = iota
+	// DialectPostgres binds params with $1, $2, ... ordinal placeholders, a repeated param reuses its ordinal
+	DialectPostgres
+)
+
+// Whitespace is how the whitespace of the generated SQL is handled
+type Whitespace int
+
+const (
+	// WhitespaceKeep keeps the whitespace of the generated SQL as is, it is the default
+	WhitespaceKeep Whitespace = iota
+	// WhitespaceCollapse collapses every run of whitespace to a single space and trims the generated SQL, the whitespace
+	// in quoted strings and identifiers is kept and a line comment keeps the line break that ends it
+	WhitespaceCollapse
+)
+
+// EmptyList is what an empty or nil slice param expands to, since IN () is not valid SQL
+type EmptyList int
+
+const (
+	// EmptyListNull expands to (NULL), it is the default. Both x IN (NULL) and x NOT IN (NULL) match no rows.
+	EmptyListNull EmptyList = iota
+	// EmptyListNoRows expands to a subquery that returns no rows, so x IN matches no rows and x NOT IN matches every row
+	// like they would for an empty set
+	EmptyListNoRows
+	// EmptyListError makes the generation of the query return ErrEmptyList
+	EmptyListError
+)
+
+// Option configures a QueryTemplate, it can be passed to NewWithOptions, MustWithOptions and Parse
+type Option interface {
+	apply(*options)
+}
+
+// options holds the configuration of a QueryTemplate
+type options struct {
+	funcs              Functions
+	tagNames           []string
+	noRewrite          bool
+	stmtRecovery       bool
+	strictColumns      bool
+	dialect            Dialect
+	parseCache         bool
+	tracer             Tracer
+	slowQueryThreshold time.Duration
+	leftDelim          string
+	rightDelim         string
+	multiStatement     bool
+	strictParams       bool
+	nullAsZero         bool
+	whitespace         Whitespace
+	collectBatchErrors bool
+	emptyList          EmptyList
+	positionalScan     bool
+	floatFormat        byte
+	floatPrecision     int
+	keyColumns         []string
+	updateColumns      []string
+}
+
+// optionFunc adapts a function to the Option interface
+type optionFunc func(*options)
+
+func (f optionFunc) apply(opts *options) {
+	f(opts)
+}
+
+// WithFunctions merges custom template functions on top of the built-in and registered functions, overriding the
+// functions with the same name, see RegisterDefaultFuncs for the functions that can't be replaced.
+//
+// Example usage:
+//
+//	query, err := NewWithOptions[User]("INSERT INTO User (uuid) VALUES ('{{ uuid }}')", WithFunctions(Functions{"uuid": newUUID}))
+//
+// Parameters:
+//   - functions: The functions, a template.FuncMap
+//
+// Returns:
+//   - Option: The option to pass to NewWithOptions
+func WithFunctions(functions Functions) Option {
+	return optionFunc(func(opts *options) {
+		maps.Copy(opts.funcs, functions)
+	})
+}
+
+// functionOptions wraps the template functions passed to New and Must in WithFunctions options
+//
+// Parameters:
+//   - maybeFunctions: The template functions
+//
+// Returns:
+//   - []Option: A WithFunctions option per map of functions
+func functionOptions(maybeFunctions []Functions) []Option {
+	options := make([]Option, len(maybeFunctions))
+	for i, functions := range maybeFunctions {
+		options[i] = WithFunctions(functions)
+	}
+	return options
+}
+
+// newOptions creates the options with the defaults and applies the given options in order
+//
+// Parameters:
+//   - maybeOptions: The options to apply
+//
+// Returns:
+//   - options: The resulting options
+func newOptions(maybeOptions ...Option) options {
+	defaultFunctionsMu.RLock()
+	opts := options{
+		funcs:          maps.Clone(defaultFunctions),
+		tagNames:       defaultTagNames,
+		floatFormat:    'g',
+		floatPrecision: -1,
+	}
+	defaultFunctionsMu.RUnlock()
+	for _, option := range maybeOptions {
+		if option != nil {
+			option.apply(&opts)
+		}
+	}
+	return opts
+}
+
+// RegisterDefaultFuncs merges the functions into the default template functions available to every query created
+// afterwards, a later registration overrides the functions with the same name. The precedence is built-in functions,
+// then registered functions, then functions passed to New, except for the functions bound by every query generation,
+// param, paramAt, named, lit, ident and tql, which can't be registered. This is meant to be called once at startup,
+// e.g. in an init function.
+//
+// Example usage:
+//
+//	func init() {
+//	    if err := tql.RegisterDefaultFuncs(tql.Functions{"tenant": func() string { return "acme" }}); err != nil {
+//	        panic(err)
+//	    }
+//	}
+//
+// Parameters:
+//   - functions: The functions to register
+//
+// Returns:
+//   - error: ErrInvalidArgument if a function has a reserved name, in which case no function is registered
+func RegisterDefaultFuncs(functions Functions) error {
+	for _, name := range reservedFunctions {
+		if _, ok := functions[name]; ok {
+			log.Error("a reserved function can't be registered", "function", name)
+			return fmt.Errorf("%w: %s is a reserved function", ErrInvalidArgument, name)
+		}
+	}
+	defaultFunctionsMu.Lock()
+	defer defaultFunctionsMu.Unlock()
+	maps.Copy(defaultFunctions, functions)
+	return nil
+}
+
+// WithTagNames sets the struct tags column names are read from, in order of precedence.
+// By default the tql tag is read first and the db tag is used as a fallback.
+// The omit option is always read from the tql tag regardless of the tag names.
+//
+// Example usage:
+//
+//	query, err := NewWithOptions[User]("SELECT * FROM User", WithTagNames("tql", "json"))
+//
+// Parameters:
+//   - tagNames: The struct tag names
+//
+// Returns:
+//   - Option: The option to pass to NewWithOptions
+func WithTagNames(tagNames ...string) Option {
+	return optionFunc(func(opts *options) {
+		opts.tagNames = tagNames
+	})
+}
+
+// WithNoRewrite keeps the projection of the SELECT statement as written instead of rewriting it with the matched columns.
+// The indices of the fields are still computed, so the projection must list the columns in the same order as the struct fields.
+// This is useful for hand-tuned projections with casts and functions that should not be touched.
+//
+// Returns:
+//   - Option: The option to pass to NewWithOptions
+func WithNoRewrite() Option {
+	return optionFunc(func(opts *options) {
+		opts.noRewrite = true
+	})
+}
+
+// WithStmtRecovery re-prepares a QueryStmt once and retries the execution when the driver reports that the prepared
+// statement is no longer valid, e.g. "Unknown prepared statement handler" after a MySQL server restart or a DDL change.
+// This is useful for long-lived cached statements.
+//
+// Returns:
+//   - Option: The option to pass to NewWithOptions
+func WithStmtRecovery() Option {
+	return optionFunc(func(opts *options) {
+		opts.stmtRecovery = true
+	})
+}
+
+// WithStrictColumns validates the projection against the struct when the query is created, see QueryTemplate.Validate.
+// New returns ErrUnmatchedColumns when a field or a column doesn't match.
+//
+// Returns:
+//   - Option: The option to pass to NewWithOptions
+func WithStrictColumns() Option {
+	return optionFunc(func(opts *options) {
+		opts.strictColumns = true
+	})
+}
+
+// WithDialect sets the SQL dialect the param placeholders are generated for, the default is DialectMySQL.
+// With DialectPostgres a param referenced more than once with the same path and value, e.g. {{ param .Id }} in both
+// the WHERE and ORDER BY clauses, reuses the ordinal it was first bound to and its value is only bound once.
+//
+// Example usage:
+//
+//	query, err := NewWithOptions[User]("SELECT * FROM users WHERE id = {{ param .Id }}", WithDialect(DialectPostgres))
+//
+// Parameters:
+//   - dialect: The SQL dialect
+//
+// Returns:
+//   - Option: The option to pass to NewWithOptions
+func WithDialect(dialect Dialect) Option {
+	return optionFunc(func(opts *options) {
+		opts.dialect = dialect
+	})
+}
+
+// WithParseCache shares the parse results of PrepareContext between all the query templates created with this option.
+// Templates that generate the same SQL for the same type only parse it once, the results are kept in a global
+// least recently used cache that can be cleared with ClearParseCache.
+//
+// Returns:
+//   - Option: The option to pass to NewWithOptions
+func WithParseCache() Option {
+	return optionFunc(func(opts *options) {
+		opts.parseCache = true
+	})
+}
+
+// WithTracer creates spans named tql.prepare, tql.query and tql.exec around the preparation and execution of the
+// query, each with a tql.driver.* child span for the driver call. The spans record the SQL with its placeholders but
+// never the bound values, and are marked as errored when the operation fails. No spans are created without a tracer.
+// The oteltql package creates OpenTelemetry spans.
+//
+// Example usage:
+//
+//	query, err := NewWithOptions[User]("SELECT * FROM User", WithTracer(oteltql.NewTracer(otel.Tracer("users"))))
+//
+// Parameters:
+//   - tracer: The tracer creating the spans
+//
+// Returns:
+//   - Option: The option to pass to NewWithOptions
+func WithTracer(tracer Tracer) Option {
+	return optionFunc(func(opts *options) {
+		opts.tracer = tracer
+	})
+}
+
+// WithSlowQueryThreshold logs a warning with the SQL, the number of bound arguments and the elapsed time when the
+// execution of the query, including the scan of the rows, takes longer than the threshold.
+// The values of the arguments are not logged. Nothing is timed when the threshold is not positive.
+//
+// Example usage:
+//
+//	query, err := NewWithOptions[User]("SELECT * FROM User", WithSlowQueryThreshold(500*time.Millisecond))
+//
+// Parameters:
+//   - threshold: The duration above which a query is logged
+//
+// Returns:
+//   - Option: The option to pass to NewWithOptions
+func WithSlowQueryThreshold(threshold time.Duration) Option {
+	return optionFunc(func(opts *options) {
+		opts.slowQueryThreshold = threshold
+	})
+}
+
+// WithDelims sets the action delimiters of the template, e.g. [[ and ]] for SQL that contains a literal {{.
+// An empty delimiter stands for the default {{ or }}. The built-in functions such as param and tql are called with the
+// configured delimiters like any other function, e.g. [[ param .Id ]].
+//
+// Example usage:
+//
+//	query, err := NewWithOptions[User]("SELECT * FROM User WHERE User.id = [[ param .Id ]]", WithDelims("[[", "]]"))
+//
+// Parameters:
+//   - left: The left delimiter
+//   - right: The right delimiter
+//
+// Returns:
+//   - Option: The option to pass to NewWithOptions
+func WithDelims(left, right string) Option {
+	return optionFunc(func(opts *options) {
+		opts.leftDelim = left
+		opts.rightDelim = right
+	})
+}
+
+// WithMultiStatement allows the generated SQL to hold more than one statement separated by semicolons.
+// By default PrepareContext returns ErrMultipleStatements for such SQL, so a value interpolated into the template,
+// e.g. {{ .Where }}, can't append a statement even when the driver is configured to run multiple statements.
+//
+// Parameters:
+//   - allowed: True to allow multiple statements
+//
+// Returns:
+//   - Option: The option to pass to NewWithOptions
+func WithMultiStatement(allowed bool) Option {
+	return optionFunc(func(opts *options) {
+		opts.multiStatement = allowed
+	})
+}
+
+// WithStrictParams rejects values interpolated into a quoted string literal, e.g. WHERE name = '{{ .Name }}',
+// which is an injection risk, steering towards binding the value with param instead.
+// The generation of such a query returns ErrInterpolatedValue, so a test of the query catches it in code review.
+//
+// Returns:
+//   - Option: The option to pass to NewWithOptions
+func WithStrictParams() Option {
+	return optionFunc(func(opts *options) {
+		opts.strictParams = true
+	})
+}
+
+// WithNullAsZero scans a NULL column into the zero value of a bool, number or string field instead of returning
+// ErrNullIntoNonNullable. Pointers and sql.Null fields still tell a NULL apart from the zero value.
+//
+// Returns:
+//   - Option: The option to pass to NewWithOptions
+func WithNullAsZero() Option {
+	return optionFunc(func(opts *options) {
+		opts.nullAsZero = true
+	})
+}
+
+// WithFloatFormat sets how the lit template function formats floats, with the format and the precision of
+// strconv.FormatFloat. The default is 'g' with the precision -1, the shortest representation that may use an exponent,
+// 'f' formats fixed-point numbers for SQL parsers that reject exponents.
+//
+// Example usage:
+//
+//	query, err := NewWithOptions[User]("SELECT * FROM User WHERE User.balance > {{ lit .Balance }}", WithFloatFormat('f', 2))
+//
+// Parameters:
+//   - format: The format, one of 'f', 'e', 'E', 'g' or 'G'
+//   - precision: The number of digits, -1 for the fewest digits that represent the float exactly
+//
+// Returns:
+//   - Option: The option to pass to NewWithOptions
+func WithFloatFormat(format byte, precision int) Option {
+	return optionFunc(func(opts *options) {
+		opts.floatFormat = format
+		opts.floatPrecision = precision
+	})
+}
+
+// WithWhitespace sets how the whitespace of the generated SQL is handled, the default is WhitespaceKeep.
+// WhitespaceCollapse removes the blank lines and the indentation left by the template actions, so the SQL reads well
+// in logs and EXPLAIN and templates that only differ in their layout generate the same SQL.
+//
+// Example usage:
+//
+//	query, err := NewWithOptions[User]("SELECT *\n  FROM User\n  WHERE name = 'John  Doe'", WithWhitespace(WhitespaceCollapse))
+//	// SELECT * FROM User WHERE name = 'John  Doe'
+//
+// Parameters:
+//   - whitespace: How the whitespace is handled
+//
+// Returns:
+//   - Option: The option to pass to NewWithOptions
+func WithWhitespace(whitespace Whitespace) Option {
+	return optionFunc(func(opts *options) {
+		opts.whitespace = whitespace
+	})
+}
+
+// WithCollectBatchErrors makes QueryStmt.ExecBatchContext execute every param set and join the errors of the failed
+// executions instead of stopping on the first error, e.g. to load the valid rows of a file and report the others.
+//
+// Returns:
+//   - Option: The option to pass to NewWithOptions
+func WithCollectBatchErrors() Option {
+	return optionFunc(func(opts *options) {
+		opts.collectBatchErrors = true
+	})
+}
+
+// WithEmptyList sets what an empty or nil slice param expands to, the default is EmptyListNull.
+// A slice with elements expands to one placeholder per element, e.g. (?) for a single element.
+//
+// Example usage:
+//
+//	query, err := NewWithOptions[User]("SELECT * FROM User WHERE id NOT IN {{ param .Ids }}", WithEmptyList(EmptyListNoRows))
+//
+// Parameters:
+//   - emptyList: What an empty list expands to
+//
+// Returns:
+//   - Option: The option to pass to NewWithOptions
+func WithEmptyList(emptyList EmptyList) Option {
+	return optionFunc(func(opts *options) {
+		opts.emptyList = emptyList
+	})
+}
+
+// WithPositionalScan scans the projected columns into the struct fields by position in declaration order, ignoring
+// their names, when the SELECT projects an explicit column list. The projection is kept as written and the query
+// fails with ErrColumnMismatch if the number of columns differs from the number of fields. A projection with a *
+// is still matched by name.
+//
+// Example usage:
+//
+//	query, err := NewWithOptions[User]("SELECT id, name, createdAt FROM User", WithPositionalScan())
+//
+// Returns:
+//   - Option: The option to pass to NewWithOptions
+func WithPositionalScan() Option {
+	return optionFunc(func(opts *options) {
+		opts.positionalScan = true
+	})
+}
+
+// WithKey sets the columns identifying the row for DeleteByPK and UpdateByPK instead of the fields tagged with the
+// pk flag, e.g. to update a row by a unique column that is not its primary key. UpdateByPK still never sets the
+// primary key columns.
+//
+// Example usage:
+//
+//	result, err := UpdateByPK(ctx, db, user, WithKey("uuid"))
+//
+// Parameters:
+//   - columns: The key columns, which must be columns of the struct
+//
+// Returns:
+//   - Option: The option to pass to DeleteByPK or UpdateByPK
+func WithKey(columns ...string) Option {
+	return optionFunc(func(opts *options) {
+		opts.keyColumns = columns
+	})
+}
+
+// WithColumns sets the columns UpdateByPK sets for a partial update, every column that is not part of the key is set
+// by default.
+//
+// Example usage:
+//
+//	result, err := UpdateByPK(ctx, db, user, WithColumns("name"))
+//
+// Parameters:
+//   - columns: The columns to set, which must be columns of the struct that are not part of the key
+//
+// Returns:
+//   - Option: The option to pass to UpdateByPK
+func WithColumns(columns ...string) Option {
+	return optionFunc(func(opts *options) {
+		opts.updateColumns = columns
+	})
+}
@@ -0,0 +1,74 @@
+// Package oteltql traces the queries of tql with OpenTelemetry, keeping OpenTelemetry out of the dependencies of the
+// tql package for the programs that don't trace.
+//
+// Example usage:
+//
+//	query, err := tql.NewWithOptions[User]("SELECT * FROM User", tql.WithTracer(oteltql.NewTracer(otel.Tracer("users"))))
+package oteltql
+
+import (
+	"context"
+
+	tql "github.com/runpod/go-tql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer adapts an OpenTelemetry tracer to tql.Tracer
+type tracer struct {
+	tracer trace.Tracer
+}
+
+// span adapts an OpenTelemetry span to tql.Span
+type span struct {
+	span trace.Span
+}
+
+// NewTracer creates a tql.Tracer starting client spans with the OpenTelemetry tracer. The spans record the SQL
+// with its placeholders as the db.statement attribute and are marked as errored when the operation fails.
+//
+// Parameters:
+//   - otelTracer: The OpenTelemetry tracer creating the spans
+//
+// Returns:
+//   - tql.Tracer: The tracer to pass to tql.WithTracer
+func NewTracer(otelTracer trace.Tracer) tql.Tracer {
+	return tracer{tracer: otelTracer}
+}
+
+// Start starts a client span named after the traced operation
+//
+// Parameters:
+//   - ctx: The parent context
+//   - name: The name of the span
+//
+// Returns:
+//   - context.Context: The context holding the span
+//   - tql.Span: The started span
+func (tracer tracer) Start(ctx context.Context, name string) (context.Context, tql.Span) {
+	ctx, otelSpan := tracer.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient))
+	return ctx, span{span: otelSpan}
+}
+
+// SetSQL records the SQL with its placeholders as the db.statement attribute
+//
+// Parameters:
+//   - sql: The SQL with placeholders
+func (span span) SetSQL(sql string) {
+	if span.span.IsRecording() {
+		span.span.SetAttributes(attribute.String("db.statement", sql))
+	}
+}
+
+// End marks the span as errored if there is an error and ends it
+//
+// Parameters:
+//   - err: The error of the traced operation, nil if it succeeded
+func (span span) End(err error) {
+	if err != nil {
+		span.span.RecordError(err)
+		span.span.SetStatus(codes.Error, err.Error())
+	}
+	span.span.End()
+}
@@ -0,0 +1,69 @@
+package oteltql
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recordingTracer records the spans it starts
+type recordingTracer struct {
+	noop.Tracer
+	spans *[]*recordingSpan
+}
+
+func (tracer recordingTracer) Start(ctx context.Context, name string, options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	span := &recordingSpan{name: name}
+	config := trace.NewSpanStartConfig(options...)
+	span.kind = config.SpanKind()
+	*tracer.spans = append(*tracer.spans, span)
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+// recordingSpan records the kind, statement, status and end of a span
+type recordingSpan struct {
+	noop.Span
+	name      string
+	kind      trace.SpanKind
+	statement string
+	failed    bool
+	ended     bool
+}
+
+func (span *recordingSpan) IsRecording() bool { return true }
+
+func (span *recordingSpan) SetAttributes(attributes ...attribute.KeyValue) {
+	for _, kv := range attributes {
+		if kv.Key == "db.statement" {
+			span.statement = kv.Value.AsString()
+		}
+	}
+}
+
+func (span *recordingSpan) SetStatus(code codes.Code, _ string) { span.failed = code == codes.Error }
+
+func (span *recordingSpan) End(...trace.SpanEndOption) { span.ended = true }
+
+func TestNewTracer(t *testing.T) {
+	spans := []*recordingSpan{}
+	tracer := NewTracer(recordingTracer{spans: &spans})
+	ctx, span := tracer.Start(context.Background(), "tql.query")
+	if trace.SpanFromContext(ctx) != spans[0] {
+		t.Fatal("expected the context to hold the span")
+	}
+	span.SetSQL("SELECT id FROM User WHERE User.id = ?")
+	span.End(nil)
+	_, failed := tracer.Start(context.Background(), "tql.exec")
+	failed.End(errors.New("duplicate key"))
+	if spans[0].name != "tql.query" || spans[0].kind != trace.SpanKindClient || spans[0].statement != "SELECT id FROM User WHERE User.id = ?" {
+		t.Fatal("unexpected span", spans[0])
+	}
+	if spans[0].failed || !spans[0].ended || !spans[1].failed || !spans[1].ended {
+		t.Fatal("expected only the failed span to be marked as errored and both to be ended")
+	}
+}
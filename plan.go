@@ -0,0 +1,179 @@
+package tql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// Plan is a QueryTemplate generated and parsed for a set of template data, which is the work of PrepareContext that
+// doesn't depend on a database. A plan is immutable and safe to cache and to prepare concurrently against several
+// databases, e.g. a primary and a read replica, without generating and parsing the template again.
+type Plan[T any] struct {
+	query      *QueryTemplate[T]
+	sql        string
+	sqlParams  []any
+	paramPaths []string
+	indices    [][]int
+	rest       []int
+	layout     []scanField
+	// placeholders is the number of arguments the SQL expects, -1 if it can't be told
+	placeholders int
+}
+
+// Plan generates and parses the query with the template data without a database.
+//
+// Example usage:
+//
+//	plan, err := query.Plan(Params{"Id": 1})
+//	primaryStmt, err := plan.Prepare(primary)
+//	replicaStmt, err := plan.Prepare(replica)
+//
+// Parameters:
+//   - data: Optional variadic parameters to pass to the template execution
+//
+// Returns:
+//   - *Plan[T]: The plan that can be prepared against any database
+//   - error: If the template execution or the parsing fails
+func (query *QueryTemplate[T]) Plan(data ...any) (*Plan[T], error) {
+	if query == nil {
+		log.Error("Plan called on a nil query")
+		return nil, errors.Join(ErrPreparingQuery, ErrNilQuery)
+	}
+	if query.template == nil {
+		log.Error("Plan called with a nil template")
+		return nil, errors.Join(ErrPreparingQuery, ErrNilTemplate)
+	}
+	return newPlan(context.Background(), query, data...)
+}
+
+// newPlan generates and parses the query with the template data, see QueryTemplate.Plan
+//
+// Parameters:
+//   - ctx: The context for logging
+//   - query: The QueryTemplate to plan. Must not be nil.
+//   - data: Optional variadic parameters to pass to the template execution
+//
+// Returns:
+//   - *Plan[T]: The plan
+//   - error: If the template execution or the parsing fails
+func newPlan[T any](ctx context.Context, query *QueryTemplate[T], data ...any) (*Plan[T], error) {
+	template, err := query.template.Clone()
+	if err != nil {
+		log.ErrorContext(ctx, "Error cloning template", "error", err)
+		return nil, errors.Join(ErrPreparingQuery, err)
+	}
+	generatedSQL, sqlParams, paramPaths, err := generate[T](template, query.options, data...)
+	if err != nil {
+		log.ErrorContext(ctx, "Error parsing sql template", "error", err)
+		return nil, errors.Join(ErrPreparingQuery, err)
+	}
+	if query.options.whitespace == WhitespaceCollapse {
+		generatedSQL = collapseWhitespace(generatedSQL, query.options.dialect)
+	}
+	if !query.options.multiStatement {
+		// an interpolated value such as {{ .Where }} must not be able to append another statement
+		if count := countStatements(generatedSQL, query.options.dialect); count > 1 {
+			log.ErrorContext(ctx, "the generated sql holds multiple statements", "statements", count)
+			return nil, errors.Join(ErrPreparingQuery, fmt.Errorf("%w: the generated sql holds %d statements", ErrMultipleStatements, count))
+		}
+	}
+	rest, err := restField(reflect.TypeFor[T](), query.options.tagNames)
+	if err != nil {
+		return nil, errors.Join(ErrPreparingQuery, err)
+	}
+	transformedSQL, indices, err := query.parse(generatedSQL)
+	if err != nil {
+		log.ErrorContext(ctx, "Error parsing sql", "error", err)
+		return nil, errors.Join(ErrPreparingQuery, err)
+	}
+	return &Plan[T]{
+		query: query,
+		sql:   transformedSQL,
+		// the statements append the execution args to the params, clipping makes them copy instead of sharing the array
+		sqlParams:    slices.Clip(sqlParams),
+		paramPaths:   paramPaths,
+		indices:      indices,
+		rest:         rest,
+		layout:       newScanLayout(reflect.TypeFor[T](), indices, query.options.tagNames),
+		placeholders: countPlaceholders(transformedSQL, query.options.dialect),
+	}, nil
+}
+
+// SQL returns the transformed SQL of the plan with its placeholders
+//
+// Returns:
+//   - string: The SQL that is prepared
+func (plan *Plan[T]) SQL() string {
+	return plan.sql
+}
+
+// PrepareContext prepares the plan against the database, see PrepareContext for more details
+//
+// Parameters:
+//   - ctx: The context for the statement preparation
+//   - txOrDb: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//
+// Returns:
+//   - *QueryStmt[T]: The prepared statement
+//   - error: If the statement preparation fails
+func (plan *Plan[T]) PrepareContext(ctx context.Context, txOrDb Preparer) (queryStmt *QueryStmt[T], err error) {
+	if plan == nil {
+		log.ErrorContext(ctx, "Prepare called on a nil plan")
+		return nil, errors.Join(ErrPreparingQuery, ErrNilQuery)
+	}
+	if isNil(txOrDb) {
+		log.ErrorContext(ctx, "Prepare called with a nil tx or db")
+		return nil, errors.Join(ErrPreparingQuery, ErrPreparingQuery)
+	}
+	ctx, span := startSpan(ctx, plan.query.options, "tql.prepare")
+	defer func() { span.End(err) }()
+	return plan.prepare(ctx, span, txOrDb)
+}
+
+// Prepare prepares the plan against the database, see PrepareContext for more details
+//
+// Parameters:
+//   - txOrDb: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//
+// Returns:
+//   - *QueryStmt[T]: The prepared statement
+//   - error: If the statement preparation fails
+func (plan *Plan[T]) Prepare(txOrDb Preparer) (*QueryStmt[T], error) {
+	return plan.PrepareContext(context.Background(), txOrDb)
+}
+
+// prepare prepares the SQL of the plan with the driver
+//
+// Parameters:
+//   - ctx: The context of the tql.prepare span
+//   - span: The tql.prepare span
+//   - txOrDb: Database connection. Must not be nil.
+//
+// Returns:
+//   - *QueryStmt[T]: The prepared statement
+//   - error: If the driver fails to prepare the SQL
+func (plan *Plan[T]) prepare(ctx context.Context, span Span, txOrDb Preparer) (*QueryStmt[T], error) {
+	span.SetSQL(plan.sql)
+	driverCtx, driverSpan := startSpan(ctx, plan.query.options, "tql.driver.prepare")
+	stmt, err := txOrDb.PrepareContext(driverCtx, plan.sql)
+	driverSpan.End(err)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to prepare query", "error", err)
+		return nil, errors.Join(ErrPreparingQuery, err)
+	}
+	return &QueryStmt[T]{
+		template:     plan.query,
+		preparer:     txOrDb,
+		indices:      plan.indices,
+		rest:         plan.rest,
+		SQL:          plan.sql,
+		prepared:     stmt,
+		sqlParams:    plan.sqlParams,
+		paramPaths:   plan.paramPaths,
+		layout:       plan.layout,
+		placeholders: plan.placeholders,
+	}, nil
+}
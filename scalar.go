@@ -0,0 +1,87 @@
+package tql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// QueryScalar executes the SQL and scans the single column of its single row into a scalar such as an int, a string
+// or a time.Time. See QueryScalarContext for more details.
+//
+// Parameters:
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - sql: The SQL to execute, it is not a template
+//   - args: Optional arguments of the placeholders
+//
+// Returns:
+//   - T: The value
+//   - error: ErrNoRows if no rows matched, ErrMultipleRows if more than one row matched or if the execution fails
+func QueryScalar[T any, Q Preparer](db Q, sql string, args ...any) (T, error) {
+	return QueryScalarContext[T](context.Background(), db, sql, args...)
+}
+
+// QueryScalarContext executes the SQL and scans the single column of its single row into a scalar such as an int,
+// a string or a time.Time, e.g. for a COUNT or an EXISTS. There is no struct to map, so the SQL is executed as is.
+// A NULL requires T to be a pointer or a sql.Null type, a registered scanner of T is used like for a struct field.
+//
+// Example usage:
+//
+//	count, err := QueryScalarContext[int](ctx, db, "SELECT COUNT(*) FROM User WHERE active = ?", true)
+//
+// Parameters:
+//   - ctx: The context for the query execution. Used for cancellation and timeouts.
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - sql: The SQL to execute, it is not a template
+//   - args: Optional arguments of the placeholders
+//
+// Returns:
+//   - T: The value
+//   - error: ErrNoRows if no rows matched, ErrMultipleRows if more than one row matched, ErrColumnMismatch if the SQL
+//     doesn't select exactly one column or if the execution fails
+func QueryScalarContext[T any, Q Preparer](ctx context.Context, db Q, sql string, args ...any) (T, error) {
+	var result T
+	if isNil(db) {
+		log.ErrorContext(ctx, "QueryScalar called with a nil tx or db")
+		return result, errors.Join(ErrExecutingQuery, ErrPreparingQuery)
+	}
+	stmt, err := db.PrepareContext(ctx, sql)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to prepare query", "error", err)
+		return result, errors.Join(ErrPreparingQuery, err)
+	}
+	defer stmt.Close()
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return result, errors.Join(ErrExecutingQuery, err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return result, errors.Join(ErrExecutingQuery, err)
+	}
+	if len(columns) != 1 {
+		log.ErrorContext(ctx, "column count does not match the scanned fields", "expected", 1, "columns", columns)
+		return result, errors.Join(ErrExecutingQuery, fmt.Errorf("%w: expected 1 column, got %d", ErrColumnMismatch, len(columns)))
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return result, errors.Join(ErrExecutingQuery, err)
+		}
+		return result, ErrNoRows
+	}
+	if err := rows.Scan(scanDestination(reflect.ValueOf(&result).Elem(), false)); err != nil {
+		log.ErrorContext(ctx, "failed to scan row", "error", err)
+		return result, errors.Join(ErrExecutingQuery, nullScanError(err, func(int) string { return "" }))
+	}
+	if rows.Next() {
+		log.ErrorContext(ctx, "expected one row", "error", ErrMultipleRows)
+		var zero T
+		return zero, ErrMultipleRows
+	}
+	if err := rows.Err(); err != nil {
+		return result, errors.Join(ErrExecutingQuery, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,201 @@
+package tql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+var (
+	// scanners holds the registered scan wrappers keyed by field type
+	scanners = map[reflect.Type]func(any) sql.Scanner{}
+
+	// scannersMu guards scanners against concurrent registrations
+	scannersMu sync.RWMutex
+
+	// nullScanRegex matches the database/sql error of a NULL column scanned into a field that can't hold a NULL
+	nullScanRegex = regexp.MustCompile(`Scan error on column index ([0-9]+), name "([^"]*)": converting NULL to`)
+
+	// scannerType is the reflected sql.Scanner interface
+	scannerType = reflect.TypeFor[sql.Scanner]()
+)
+
+// RegisterScanner registers a wrapper for the fields of the given type, the scan destination of such a field is the
+// scanner returned by the wrapper for the pointer to the field. This enables columns the driver can't scan into the
+// field directly, such as postgres arrays with pq.Array or JSON columns, without tql depending on a driver.
+// This is meant to be called once at startup, e.g. in an init function.
+//
+// Example usage:
+//
+//	func init() {
+//	    tql.RegisterScanner(reflect.TypeFor[[]string](), func(field any) sql.Scanner { return pq.Array(field) })
+//	}
+//
+// Parameters:
+//   - fieldType: The type of the fields to wrap
+//   - wrap: The function that wraps the pointer to a field in a scanner
+func RegisterScanner(fieldType reflect.Type, wrap func(any) sql.Scanner) {
+	scannersMu.Lock()
+	defer scannersMu.Unlock()
+	scanners[fieldType] = wrap
+}
+
+// scanDestination returns the scan destination of a field, which is the registered scanner wrapping the pointer to the
+// field or the pointer itself
+//
+// Parameters:
+//   - field: The addressable field
+//   - nullAsZero: True to scan a NULL into the zero value of a bool, number or string field, see WithNullAsZero
+//
+// Returns:
+//   - any: The scan destination
+func scanDestination(field reflect.Value, nullAsZero bool) any {
+	scannersMu.RLock()
+	wrap, ok := scanners[field.Type()]
+	scannersMu.RUnlock()
+	if ok {
+		return wrap(field.Addr().Interface())
+	}
+	if nullAsZero && !field.Addr().Type().Implements(scannerType) {
+		switch field.Kind() {
+		case reflect.Bool, reflect.String,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			return zeroNullScanner{field}
+		}
+	}
+	return field.Addr().Interface()
+}
+
+// zeroNullScanner scans a NULL into the zero value of a bool, number or string field and any other value like
+// database/sql would scan it into the field
+type zeroNullScanner struct {
+	field reflect.Value
+}
+
+// Scan implements sql.Scanner
+func (scanner zeroNullScanner) Scan(src any) error {
+	if src == nil {
+		scanner.field.SetZero()
+		return nil
+	}
+	// the sql.Null types convert the value like rows.Scan, the kind of the field may be a named type such as Status
+	switch scanner.field.Kind() {
+	case reflect.Bool:
+		var value sql.Null[bool]
+		if err := value.Scan(src); err != nil {
+			return err
+		}
+		scanner.field.SetBool(value.V)
+	case reflect.String:
+		var value sql.Null[string]
+		if err := value.Scan(src); err != nil {
+			return err
+		}
+		scanner.field.SetString(value.V)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var value sql.Null[int64]
+		if err := value.Scan(src); err != nil {
+			return err
+		}
+		if scanner.field.OverflowInt(value.V) {
+			return fmt.Errorf("converting %v to %s overflows", src, scanner.field.Type())
+		}
+		scanner.field.SetInt(value.V)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var value sql.Null[uint64]
+		if err := value.Scan(src); err != nil {
+			return err
+		}
+		if scanner.field.OverflowUint(value.V) {
+			return fmt.Errorf("converting %v to %s overflows", src, scanner.field.Type())
+		}
+		scanner.field.SetUint(value.V)
+	case reflect.Float32, reflect.Float64:
+		var value sql.Null[float64]
+		if err := value.Scan(src); err != nil {
+			return err
+		}
+		scanner.field.SetFloat(value.V)
+	}
+	return nil
+}
+
+// nullScanError wraps the error of a NULL column scanned into a field that can't hold a NULL with
+// ErrNullIntoNonNullable, naming the column and the field
+//
+// Parameters:
+//   - err: The error returned by rows.Scan
+//   - fieldName: The function returning the name of the field of a column index, or an empty string if it is unknown
+//
+// Returns:
+//   - error: The wrapped error, or the error as is if it is not caused by a NULL
+func nullScanError(err error, fieldName func(column int) string) error {
+	match := nullScanRegex.FindStringSubmatch(err.Error())
+	if match == nil {
+		return err
+	}
+	column, _ := strconv.Atoi(match[1])
+	field := fieldName(column)
+	log.Error("NULL scanned into a field that can't hold a NULL", "column", match[2], "field", field)
+	if field == "" {
+		return errors.Join(fmt.Errorf("%w: column %s, use a pointer, a sql.Null type or WithNullAsZero", ErrNullIntoNonNullable, match[2]), err)
+	}
+	return errors.Join(fmt.Errorf("%w: column %s into field %s, use a pointer, a sql.Null type or WithNullAsZero", ErrNullIntoNonNullable, match[2], field), err)
+}
+
+// hasScanner checks if a scanner is registered for the type
+//
+// Parameters:
+//   - fieldType: The type to check
+//
+// Returns:
+//   - bool: True if a scanner is registered, false otherwise
+func hasScanner(fieldType reflect.Type) bool {
+	scannersMu.RLock()
+	defer scannersMu.RUnlock()
+	_, ok := scanners[fieldType]
+	return ok
+}
+
+// scanField is the layout of a scanned field, computed once per plan so the row loop doesn't read the tags of every
+// field on every query
+type scanField struct {
+	// index is the index path of the field in T
+	index []int
+	// json is true if the column is decoded from JSON into the field
+	json bool
+}
+
+// newScanLayout computes the layout of the scanned fields
+//
+// Parameters:
+//   - reflectedType: The reflected type of the scanned struct
+//   - indices: The index paths of the scanned fields in column order
+//   - tagNames: The struct tags to read the json flag from
+//
+// Returns:
+//   - []scanField: The layout of the fields in column order
+func newScanLayout(reflectedType reflect.Type, indices [][]int, tagNames []string) []scanField {
+	layout := make([]scanField, len(indices))
+	for i, index := range indices {
+		layout[i] = scanField{index: index, json: parseTQLTag(reflectedType.FieldByIndex(index), tagNames).json}
+	}
+	return layout
+}
+
+// value returns the addressable field of the scan destination
+//
+// Parameters:
+//   - scanDest: The addressable scan destination
+//
+// Returns:
+//   - reflect.Value: The addressable field
+func (field scanField) value(scanDest reflect.Value) reflect.Value {
+	return scanDest.FieldByIndex(field.index)
+}
@@ -1,31 +1,100 @@
 // Package tql provides a type-safe SQL query builder and executor that uses Go templates
 // and struct reflection to generate and execute SQL queries.
+//
+// The functions that reach the database have a Context variant, e.g. QueryContext for Query, whose context is passed
+// to the PrepareContext, QueryContext and ExecContext calls of the driver with its values, such as the routing hints
+// of a replica aware Preparer. The variants without a context use context.Background().
 package tql
 
 import (
 	"bytes"
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"iter"
 	"log/slog"
-	"maps"
+	"math"
+	"math/big"
 	"reflect"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"text/template"
+	parsetree "text/template/parse"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 var (
 	// log is the package logger
 	log = slog.Default().WithGroup("tql")
 
-	// tagRegex matches key=value pairs in struct tags
-	tagRegex = regexp.MustCompile(`(\w+)(?:=([^;]*))?`)
-
 	// selectRegex matches SELECT statements to parse column selection
 	selectRegex = regexp.MustCompile(`(?m)(?is)SELECT\s+(.+?)\s+FROM\b`)
 
+	// returningRegex matches the RETURNING clause that ends an INSERT, UPDATE or DELETE statement to parse column selection
+	returningRegex = regexp.MustCompile(`(?is)^\s*(?:INSERT|UPDATE|DELETE)\b.*\bRETURNING\s+(.+?)\s*;?\s*$`)
+
+	// distinctRegex matches a leading DISTINCT or postgres DISTINCT ON (...) modifier in a projection
+	distinctRegex = regexp.MustCompile(`(?is)^\s*DISTINCT(?:\s+ON\s*\([^)]*\))?\s+`)
+
+	// unionRegex matches the UNION operators between SELECT statements
+	unionRegex = regexp.MustCompile(`(?i)^\s*\bUNION(?:\s+(?:ALL|DISTINCT))?\s+`)
+
+	// tableRegex matches tables in FROM and JOIN clauses
+	tableRegex = regexp.MustCompile(`(?i)\b(?:FROM|JOIN)\s+([a-zA-Z_][a-zA-Z0-9_]*)`)
+
+	// aliasRegex matches the alias that follows a table
+	aliasRegex = regexp.MustCompile(`(?i)^\s+(?:AS\s+)?([a-zA-Z_][a-zA-Z0-9_]*)`)
+
+	// aliasKeywords are the keywords that can follow a table in FROM and JOIN clauses and are not aliases
+	aliasKeywords = map[string]bool{
+		"WHERE": true, "ON": true, "USING": true, "JOIN": true, "INNER": true, "LEFT": true, "RIGHT": true, "FULL": true,
+		"OUTER": true, "CROSS": true, "NATURAL": true, "STRAIGHT_JOIN": true, "GROUP": true, "ORDER": true, "HAVING": true,
+		"LIMIT": true, "OFFSET": true, "UNION": true, "EXCEPT": true, "INTERSECT": true, "WINDOW": true, "FOR": true,
+		"LOCK": true, "USE": true, "FORCE": true, "IGNORE": true, "PARTITION": true, "SET": true, "VALUES": true,
+		"RETURNING": true, "INTO": true,
+	}
+
+	// staleStmtRegex matches the driver errors of statements that are no longer valid on the server,
+	// mysql error 1243 and postgres error 26000
+	staleStmtRegex = regexp.MustCompile(`Unknown prepared statement handler|prepared statement "[^"]*" does not exist|sql: statement is closed`)
+
+	// columnAliasRegex matches a column alias written without AS, optionally quoted
+	columnAliasRegex = regexp.MustCompile("^(?:[a-zA-Z_][a-zA-Z0-9_]*|`[^`]+`|\"[^\"]+\")$")
+
+	// notAliases are the keywords that end an expression or start one, so they are not taken for an alias written
+	// without AS or for the expression before it, e.g. CASE ... END or NOT active
+	notAliases = map[string]bool{
+		"END": true, "NULL": true, "TRUE": true, "FALSE": true, "UNKNOWN": true, "DISTINCT": true, "ALL": true, "NOT": true,
+	}
+
+	// identRegex matches valid, optionally qualified, SQL identifiers
+	identRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)?$`)
+
+	// undefinedFuncRegex matches the text/template error of a function that is called but not defined
+	undefinedFuncRegex = regexp.MustCompile(`function "([^"]+)" not defined`)
+
+	// argumentErrorRegex matches the database/sql error of an argument the driver could not convert
+	argumentErrorRegex = regexp.MustCompile(`converting argument \$([0-9]+) type`)
+
+	// likeReplacer escapes the LIKE wildcards and the escape character
+	likeReplacer = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+	// actionStart and actionEnd delimit the output of the template actions of queries created with WithStrictParams
+	actionStart = "\x00tql{"
+	actionEnd   = "\x00tql}"
+
+	// ordinalRegex matches the postgres ordinal placeholders
+	ordinalRegex = regexp.MustCompile(`\$[0-9]+`)
+
 	// cteRegex matches CTEs to parse column selection
 	cteRegex = regexp.MustCompile(`(?ms)(?:\bWITH\s+)?([a-zA-Z_][a-zA-Z0-9_]+)\s+AS\s*\((.*?)\)`)
 
@@ -34,17 +103,39 @@ var (
 		"param": func(value any) any {
 			return "?"
 		},
+		"named": func(name string, value any) string {
+			return "@" + name
+		},
+		"like": EscapeLike,
+		"lit": func(value any) string {
+			return "NULL"
+		},
+		"ident": func(name string) string {
+			return name
+		},
 		"tql": func(query any, args ...any) any {
 			slog.Info("tql", "query", query, "args", args)
 
 			return query
 		},
+		"raw": func(value any) string {
+			ident, ok := value.(safeIdentifier)
+			if !ok {
+				panic(template.ExecError{
+					Err: errors.Join(ErrInvalidIdentifier, errors.New("raw: expected an identifier created by tql.Ident, got "+reflect.TypeOf(value).String())),
+				})
+			}
+			return string(ident)
+		},
 	}
 
 	// ErrNilQuery is returned when attempting to use a nil query
 	ErrNilQuery = errors.New("query is nil")
 	// ErrNilStmt is returned when attempting to use a nil statement
 	ErrNilStmt = errors.New("statement is nil")
+	// ErrStmtInvalidated is returned when executing a statement that was closed by database/sql, e.g. a statement
+	// prepared on a transaction that was committed or rolled back
+	ErrStmtInvalidated = errors.New("statement is invalidated, prepare it again")
 	// ErrNilTemplate is returned when attempting to use a nil template
 	ErrNilTemplate = errors.New("template is nil")
 
@@ -69,36 +160,120 @@ var (
 	// ErrInvalidType is returned when the type parameter is not a struct
 	ErrInvalidType = errors.New("failed to create query type parameter is invalid")
 
+	// ErrNoRows is returned by One when no rows matched, it is the same error as sql.ErrNoRows
+	ErrNoRows = sql.ErrNoRows
+
+	// ErrMultipleRows is returned by One when more than one row matched
+	ErrMultipleRows = errors.New("expected one row, got multiple")
+
+	// ErrColumnMismatch is returned when the number of columns returned by a query does not match the scanned fields
+	ErrColumnMismatch = errors.New("column count does not match the scanned fields")
+
+	// ErrUnmatchedColumns is returned by Validate when struct fields and projected columns don't match
+	ErrUnmatchedColumns = errors.New("struct fields and projected columns don't match")
+
+	// ErrInvalidIdentifier is returned when an identifier contains characters that are not allowed
+	ErrInvalidIdentifier = errors.New("invalid identifier")
+
+	// ErrUnsupportedUnion is returned when the branches of a UNION select different fields
+	ErrUnsupportedUnion = errors.New("union branches select different fields")
+
+	// ErrUnsupportedParam is returned when the driver can't bind the value of a param
+	ErrUnsupportedParam = errors.New("unsupported param type")
+
+	// ErrInvalidArgument is returned before the statement is executed when a bound argument is not a type the driver
+	// accepts, e.g. a struct, a map, a channel or a function
+	ErrInvalidArgument = errors.New("invalid argument")
+
+	// ErrAmbiguousField is returned when two fields of a table struct at the same depth map to the same column
+	ErrAmbiguousField = errors.New("ambiguous field")
+
+	// ErrDecodingJSON is returned when a column of a field tagged with the json flag doesn't hold valid JSON for the field
+	ErrDecodingJSON = errors.New("failed to decode json column")
+
+	// ErrNoColumns is returned when rows are queried but no struct field matches the projection of the query,
+	// e.g. a misspelled column or a statement without a SELECT projection
+	ErrNoColumns = errors.New("no struct field matches the projection")
+
+	// ErrInterpolatedValue is returned by queries created with WithStrictParams when a template action expands inside
+	// a quoted string literal, e.g. '{{ .Name }}', instead of binding the value with param
+	ErrInterpolatedValue = errors.New("value interpolated into a string literal")
+
+	// ErrNullIntoNonNullable is returned when a NULL column is scanned into a field that can't hold a NULL, such as an int
+	// or a string, the field should be a pointer or a sql.Null type or the query created with WithNullAsZero
+	ErrNullIntoNonNullable = errors.New("NULL scanned into a non-nullable field")
+
+	// ErrMultipleStatements is returned when the generated SQL holds more than one statement and the query was not
+	// created with WithMultiStatement
+	ErrMultipleStatements = errors.New("multiple statements")
+
+	// ErrArgCountMismatch is returned when the number of arguments doesn't match the number of placeholders of the
+	// transformed SQL
+	ErrArgCountMismatch = errors.New("argument count mismatch")
+
+	// ErrEmptyList is returned when a param is an empty or nil slice and the query was created with
+	// WithEmptyList(EmptyListError)
+	ErrEmptyList = errors.New("empty list param")
+
 	// ErrUnsupportedCTE is returned when the sql template contains unsupported CTEs
 	ErrUnsupportedCTE = errors.New("unsupported CTEs in sql template")
 )
 
-// Functions is an alias for template.Functions to provide custom template functions
+// Functions is an alias for template.FuncMap to provide custom template functions, see WithFunctions.
+// They are merged on top of the built-in functions so param, tql, named, raw and like stay available next to them.
 type Functions = template.FuncMap
 type Params = map[string]any
 
+// Preparer is implemented by anything that can prepare a statement, such as *sql.DB, *sql.Tx, *sql.Conn
+// or a wrapper around them that adds tracing or metrics
+type Preparer interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// DbOrTx is kept for backwards compatibility, both *sql.DB and *sql.Tx satisfy Preparer
 type DbOrTx interface {
 	*sql.DB | *sql.Tx
+	Preparer
 }
 
+// safeIdentifier is a trusted identifier that can be interpolated into SQL with the raw template function.
+// It can only be created with Ident or MustIdent.
+type safeIdentifier string
+
 // Template is an interface that represents a template that can be generated
 type Template interface {
 	Generate(maybeTemplateParams ...any) (string, []any, error)
 	MustGenerate(maybeTemplateParams ...any) (string, []any)
 }
 
+// dialectTemplate is a Template that can be generated with the placeholders of another dialect
+type dialectTemplate interface {
+	generateDialect(dialect Dialect, data ...any) (string, []any, error)
+}
+
 // QueryTemplate is a struct that represents a template that can be generated
 type QueryTemplate[T any] struct {
 	template *template.Template
+	source   string
+	options  options
 }
 
 // QueryStmt is a struct that represents a prepared statement that can be executed
 type QueryStmt[T any] struct {
-	template  *QueryTemplate[T]
-	prepared  *sql.Stmt
-	indices   [][]int
-	SQL       string
-	sqlParams []any
+	template   *QueryTemplate[T]
+	preparer   Preparer
+	mu         sync.RWMutex
+	prepared   *sql.Stmt
+	indices    [][]int
+	rest       []int
+	SQL        string
+	sqlParams  []any
+	paramPaths []string
+	layout     []scanField
+	// placeholders is the number of arguments the SQL expects, -1 if it can't be told
+	placeholders int
+	// fieldsPool holds the scan destination slices of finished queries for the next ones, see scanFields
+	fieldsPool sync.Pool
 }
 
 // New creates a new QueryTemplate with the given SQL template and optional template functions.
@@ -119,6 +294,9 @@ type QueryStmt[T any] struct {
 //	    Account Account `tql:"account"` // optional tag to specify the table alias
 //	}
 //
+// The tag of a table field names the table or alias that qualifies its columns in the SQL, e.g. `tql:"u"` matches u.id,
+// and defaults to the field name. A table named by the tag that is aliased in the FROM or JOIN clause is matched by its alias.
+//
 // The sqlTemplate parameter supports Go template syntax for dynamic SQL generation.
 // Template variables can be accessed using {{ .VarName }} syntax. see https://pkg.go.dev/text/template for more details.
 //
@@ -129,22 +307,35 @@ type QueryStmt[T any] struct {
 //
 // Optional template functions can be provided to extend template capabilities. see https://pkg.go.dev/text/template#FuncMap for more details.
 // If no functions are provided, default functions will be used.
+// Options such as WithTagNames are passed to NewWithOptions.
 //
 // Parameters:
 //   - sqlTemplate: The SQL template string to use for the query.
-//   - maybeFunctions: Optional variadic parameters to pass to the query execution
+//   - maybeFunctions: Optional template functions
 //
 // Returns:
 //   - *QueryTemplate[S]: A new QueryTemplate with the given SQL template and optional template functions.
 //   - error: If the query template parsing fails
 func New[T any](sqlTemplate string, maybeFunctions ...Functions) (*QueryTemplate[T], error) {
-	funcs := defaultFunctions
-	if len(maybeFunctions) > 0 {
-		funcs = maps.Clone(defaultFunctions)
-		for k, v := range maybeFunctions[0] {
-			funcs[k] = v
-		}
-	}
+	return NewWithOptions[T](sqlTemplate, functionOptions(maybeFunctions)...)
+}
+
+// NewWithOptions creates a new QueryTemplate like New with options such as WithTagNames or WithDialect.
+// Template functions are passed with WithFunctions.
+//
+// Example usage:
+//
+//	query, err := NewWithOptions[User]("SELECT * FROM User WHERE id = {{ param .Id }}", WithDialect(DialectPostgres))
+//
+// Parameters:
+//   - sqlTemplate: The SQL template string to use for the query.
+//   - maybeOptions: Optional options
+//
+// Returns:
+//   - *QueryTemplate[S]: A new QueryTemplate with the given SQL template and options.
+//   - error: If the query template parsing fails
+func NewWithOptions[T any](sqlTemplate string, maybeOptions ...Option) (*QueryTemplate[T], error) {
+	opts := newOptions(maybeOptions...)
 
 	var s T
 	v := reflect.ValueOf(s)
@@ -156,12 +347,29 @@ func New[T any](sqlTemplate string, maybeFunctions ...Functions) (*QueryTemplate
 		log.Error("sql template contains unsupported CTEs", "sql", sqlTemplate)
 		return nil, ErrUnsupportedCTE
 	}
-	tmpl, err := template.New(v.Type().Name()).Funcs(template.FuncMap(funcs)).Option("missingkey=zero").Parse(sqlTemplate)
+	tmpl, err := template.New(v.Type().Name()).Delims(opts.leftDelim, opts.rightDelim).Funcs(opts.funcs).Option("missingkey=zero").Parse(sqlTemplate)
 	if err != nil {
+		if match := undefinedFuncRegex.FindStringSubmatch(err.Error()); match != nil {
+			log.Error("the sql template calls an undefined function", "function", match[1], "error", err)
+			return nil, errors.Join(fmt.Errorf("%w: function %s is not defined, pass it to New or register it with RegisterDefaultFuncs", ErrParsingTemplate, match[1]), err)
+		}
 		log.Error("failed to create query with functions", "error", err)
 		return nil, errors.Join(ErrParsingTemplate, err)
 	}
-	query := &QueryTemplate[T]{template: tmpl}
+	for _, definedTemplate := range tmpl.Templates() {
+		if definedTemplate.Tree != nil {
+			annotateParamPaths(definedTemplate.Tree.Root)
+			if opts.strictParams {
+				markActions(definedTemplate.Tree.Root)
+			}
+		}
+	}
+	query := &QueryTemplate[T]{template: tmpl, source: sqlTemplate, options: opts}
+	if opts.strictColumns {
+		if err := query.Validate(); err != nil {
+			return nil, err
+		}
+	}
 	return query, nil
 }
 
@@ -175,558 +383,3129 @@ func New[T any](sqlTemplate string, maybeFunctions ...Functions) (*QueryTemplate
 //
 // Parameters:
 //   - sqlTemplate: The SQL template string to use for the query.
-//   - maybePipelines: Optional variadic parameters to pass to the query execution
+//   - maybeFunctions: Optional template functions
 //
 // Returns:
 //   - *QueryTemplate[S]: A new QueryTemplate with the given SQL template and optional template functions.
 //   - error: If the query template parsing fails
 //
 // Note: Only use Must for queries that are guaranteed to be valid, otherwise use New to handle errors gracefully.
-func Must[T any](sqlTemplate string, maybePipelines ...Functions) *QueryTemplate[T] {
-	q, err := New[T](sqlTemplate, maybePipelines...)
+func Must[T any](sqlTemplate string, maybeFunctions ...Functions) *QueryTemplate[T] {
+	return MustWithOptions[T](sqlTemplate, functionOptions(maybeFunctions)...)
+}
+
+// MustWithOptions creates a new QueryTemplate with options like NewWithOptions and panics if an error occurs.
+//
+// Example usage:
+//
+//	query := MustWithOptions[User]("SELECT * FROM users WHERE id = {{ param .Id }}", WithDialect(DialectPostgres))
+//
+// Parameters:
+//   - sqlTemplate: The SQL template string to use for the query.
+//   - maybeOptions: Optional options
+//
+// Returns:
+//   - *QueryTemplate[S]: A new QueryTemplate with the given SQL template and options.
+//
+// Note: Only use MustWithOptions for queries that are guaranteed to be valid, otherwise use NewWithOptions.
+func MustWithOptions[T any](sqlTemplate string, maybeOptions ...Option) *QueryTemplate[T] {
+	q, err := NewWithOptions[T](sqlTemplate, maybeOptions...)
 	if err != nil {
 		panic(err)
 	}
 	return q
 }
 
-// Query executes a QueryTemplate with the given database connection and optional template data.
-// It returns a slice of results of type T and any error that occurred.
+// Ident validates the name and returns an identifier that can be interpolated with the raw template function.
+// Only letters, digits and underscores are allowed, optionally qualified with a single dot (e.g. User.id).
 //
-// The type parameter T specifies the result type, which must be a struct. See New[T] for more details.
-// The type parameter Q must be either *sql.DB or *sql.Tx.
+// Example usage:
+//
+//	query := Must[User]("SELECT * FROM {{ raw .Table }}")
+//	table, err := Ident("User")
+//	results, err := Query(query, db, Params{"Table": table})
 //
 // Parameters:
-//   - query: The QueryTemplate to execute. Must not be nil.
-//   - db: Database connection, can be either *sql.DB or *sql.Tx
-//   - data: Optional variadic parameters to pass to the query execution
+//   - name: The identifier to validate
 //
 // Returns:
-//   - []T: A slice of results of type T
-//   - error: If query preparation or execution fails
-func Query[T any, Q DbOrTx](query *QueryTemplate[T], db Q, data ...any) ([]T, error) {
-	return QueryContext(query, context.Background(), db, data...)
+//   - safeIdentifier: The validated identifier
+//   - error: If the identifier contains characters that are not allowed
+func Ident(name string) (safeIdentifier, error) {
+	if !identRegex.MatchString(name) {
+		log.Error("invalid identifier", "identifier", name)
+		return "", errors.Join(ErrInvalidIdentifier, errors.New("identifier "+name+" contains invalid characters"))
+	}
+	return safeIdentifier(name), nil
 }
 
-// QueryContext executes a QueryTemplate with the given context, database connection, and optional template data.
-// It returns a slice of results of type T and any error that occurred.
-//
-// The type parameter T specifies the result type, which must be a struct. See New[S] for more details.
-// The type parameter Q must be either *sql.DB or *sql.Tx.
+// MustIdent validates the name and returns an identifier that can be interpolated with the raw template function.
+// It panics if the identifier is invalid, use it for identifiers known at compile time. See Ident for more details.
 //
 // Parameters:
-//   - query: The QueryTemplate to execute. Must not be nil.
-//   - ctx: The context for the query execution. Used for cancellation and timeouts.
-//   - db: Database connection, can be either *sql.DB or *sql.Tx
-//   - data: Optional variadic parameters to pass to the query execution
+//   - name: The identifier to validate
 //
 // Returns:
-//   - []T: A slice of results of type T
-//   - error: If query preparation or execution fails
-func QueryContext[T any, Q DbOrTx](query *QueryTemplate[T], ctx context.Context, txOrDb Q, data ...any) ([]T, error) {
-	results := []T{}
-	if query == nil {
-		log.ErrorContext(ctx, "Execute called on a nil query", "error", ErrNilQuery)
-		return results, errors.Join(ErrExecutingQuery, ErrNilQuery)
+//   - safeIdentifier: The validated identifier
+func MustIdent(name string) safeIdentifier {
+	ident, err := Ident(name)
+	if err != nil {
+		panic(err)
 	}
-	var err error
-	stmt, err := PrepareContext(query, ctx, txOrDb)
+	return ident
+}
+
+// QuoteIdent quotes an identifier for the dialect, with backticks for MySQL and double quotes for postgres, doubling
+// the quotes it contains. A qualified name such as User.id is quoted part by part. It is also available to the
+// templates as the ident function, e.g. ORDER BY {{ ident .Column }}, for identifiers that can't be bound.
+// Unlike Ident, any name is accepted since the quoting keeps it an identifier, the name should still come from an
+// allowlist since it can reference any column.
+//
+// Parameters:
+//   - name: The identifier to quote
+//   - dialect: The dialect of the quotes
+//
+// Returns:
+//   - string: The quoted identifier
+//   - error: ErrInvalidIdentifier if a part of the name is empty or the name contains a NUL byte
+func QuoteIdent(name string, dialect Dialect) (string, error) {
+	quoted, err := AppendQuoteIdent(nil, name, dialect)
 	if err != nil {
-		return results, errors.Join(ErrExecutingQuery, err)
+		return "", err
 	}
-	return stmt.QueryContext(ctx, data...)
+	return string(quoted), nil
 }
 
-// ExecContext executes a QueryTemplate with the given context, database connection, and optional template data.
-// It returns the result of the query execution and any error that occurred.
+// AppendQuoteIdent appends the identifier quoted for the dialect to dst and returns the extended buffer, see
+// QuoteIdent. Building SQL in a reused scratch buffer this way avoids allocating a string per identifier.
 //
-// The type parameter T specifies the result type, which must be a struct. See New[S] for more details.
-// The type parameter Q must be either *sql.DB or *sql.Tx.
+// Example usage:
+//
+//	scratch, err = AppendQuoteIdent(scratch[:0], table, DialectPostgres)
 //
 // Parameters:
-//   - query: The QueryTemplate to execute. Must not be nil.
-//   - ctx: The context for the query execution. Used for cancellation and timeouts.
-//   - db: Database connection, can be either *sql.DB or *sql.Tx
-//   - data: Optional variadic parameters to pass to the query execution
+//   - dst: The buffer to append to
+//   - name: The identifier to quote
+//   - dialect: The dialect of the quotes
 //
 // Returns:
-//   - sql.Result containing the execution results
-//   - error if query preparation or execution fails
-func ExecContext[T any, Q DbOrTx](query *QueryTemplate[T], ctx context.Context, db Q, data ...any) (sql.Result, error) {
-	if query == nil {
-		log.ErrorContext(ctx, "Execute called on a nil query", "error", ErrNilQuery)
-		return nil, errors.Join(ErrExecutingQuery, ErrNilQuery)
+//   - []byte: The extended buffer
+//   - error: ErrInvalidIdentifier if a part of the name is empty or the name contains a NUL byte, dst is then
+//     returned unchanged
+func AppendQuoteIdent(dst []byte, name string, dialect Dialect) ([]byte, error) {
+	quote := byte('`')
+	if dialect == DialectPostgres {
+		quote = '"'
 	}
-	stmt, err := PrepareContext(query, ctx, db)
-	if err != nil {
-		log.ErrorContext(ctx, "failed to prepare query", "error", err)
-		return nil, errors.Join(ErrExecutingQuery, err)
+	start := len(dst)
+	for i, part := range strings.Split(name, ".") {
+		if part == "" || strings.ContainsRune(part, 0) {
+			log.Error("invalid identifier", "identifier", name)
+			return dst[:start], errors.Join(ErrInvalidIdentifier, errors.New("identifier "+strconv.Quote(name)+" can't be quoted"))
+		}
+		if i > 0 {
+			dst = append(dst, '.')
+		}
+		dst = append(dst, quote)
+		for j := 0; j < len(part); j++ {
+			if part[j] == quote {
+				dst = append(dst, quote)
+			}
+			dst = append(dst, part[j])
+		}
+		dst = append(dst, quote)
 	}
-	return stmt.ExecContext(ctx, data...)
+	return dst, nil
 }
 
-// Exec executes a QueryTemplate with the given database connection and optional template data.
-// It returns the result of the query execution and any error that occurred.
+// QuoteLiteral formats a value as an SQL literal for the dialect: NULL, TRUE and FALSE, numbers as is, strings and
+// times quoted with their quotes escaped, []byte as a hex literal and maps and structs as a quoted JSON object
+// encoded with their json tags, e.g. for a JSON column. The math/big numbers are exact decimals, see QuoteDecimal.
+// It is also available to the templates as the lit function for values that can't be bound, param should be
+// preferred whenever the value can be bound. Floats are formatted like strconv.FormatFloat with 'g' and -1, the lit
+// function uses the format of WithFloatFormat.
+// A named type such as type UserId int64 is formatted like its kind and a pointer like the value it points to.
+// A postgres string has no backslash escapes unless it contains control characters, it is then an escape string,
+// e.g. E'a\nb'.
+// Slices and arrays other than []byte are a parenthesized list of their elements for an IN list, e.g. (1, 2, 3),
+// and (NULL) if empty.
+// A rune is an int32 and a byte is a uint8, so both are formatted as numbers, QuoteRune formats a character literal.
 //
-// The type parameter T specifies the result type, which must be a struct. See New[S] for more details.
-// The type parameter Q must be either *sql.DB or *sql.Tx.
+// Example usage:
+//
+//	query := Must[User](`SELECT * FROM User ORDER BY {{ ident .Column }} LIMIT {{ lit .Limit }}`)
 //
 // Parameters:
-//   - query: The QueryTemplate to execute. Must not be nil.
-//   - db: Database connection, can be either *sql.DB or *sql.Tx
-//   - data: Optional variadic parameters to pass to the query execution
+//   - value: The value to format, a driver.Valuer is formatted with its value
+//   - dialect: The dialect, a backslash is only escaped in MySQL
 //
 // Returns:
-//   - sql.Result containing the execution results
-//   - error if query preparation or execution fails
-func Exec[T any, Q DbOrTx](query *QueryTemplate[T], db Q, data ...any) (sql.Result, error) {
-	return ExecContext(query, context.Background(), db, data...)
+//   - string: The literal
+//   - error: ErrInvalidArgument if the value can't be formatted, such as a func, a NaN or a map that can't be encoded
+func QuoteLiteral(value any, dialect Dialect) (string, error) {
+	return quoteLiteral(value, dialect, 'g', -1)
 }
 
-// Generate generates the SQL template with the given data and returns the generated SQL string and any error that occurred.
+// quoteLiteral formats a value as an SQL literal for the dialect, see QuoteLiteral
 //
 // Parameters:
-//   - query: The QueryTemplate to generate. Must not be nil.
-//   - data: Optional variadic parameters to pass to the query execution
+//   - value: The value to format
+//   - dialect: The dialect
+//   - floatFormat: The strconv.FormatFloat format of floats
+//   - floatPrecision: The strconv.FormatFloat precision of floats
 //
 // Returns:
-//   - string: The generated SQL string
-//   - error: If the template execution fails
-func Generate[T any](sqlTemplate *template.Template, data ...any) (string, []any, error) {
-	if sqlTemplate == nil {
-		log.Error("Generate called on a nil query")
-		return "", nil, ErrNilQuery
+//   - string: The literal
+//   - error: ErrInvalidArgument if the value can't be formatted
+func quoteLiteral(value any, dialect Dialect, floatFormat byte, floatPrecision int) (string, error) {
+	if valuer, ok := value.(driver.Valuer); ok {
+		if reflectedValue := reflect.ValueOf(valuer); reflectedValue.Kind() == reflect.Pointer && reflectedValue.IsNil() {
+			return "NULL", nil
+		}
+		driverValue, err := valuer.Value()
+		if err != nil {
+			return "", errors.Join(ErrInvalidArgument, err)
+		}
+		value = driverValue
 	}
-	// using a pointer to the sqlParams map here so we can instantiate it in place if it is nil
-	sqlParams := &[]any{}
-	sqlTemplate.Funcs(Functions{
-		"param": func(value any) string {
-			if reflect.TypeOf(value).Kind() == reflect.Slice {
-				v := reflect.ValueOf(value)
-				placeholders := make([]string, v.Len())
-				for i := 0; i < v.Len(); i++ {
-					*sqlParams = append(*sqlParams, v.Index(i).Interface())
-					placeholders[i] = "?"
-				}
-				return "(" + strings.Join(placeholders, ",") + ")"
-			} else {
-
-				*sqlParams = append(*sqlParams, value)
-			}
-			return "?"
-		},
-		"tql": func(maybeQuery any, params ...any) any {
-			query, ok := maybeQuery.(Template)
-			if !ok {
-				panic(template.ExecError{
-					Err: errors.New("tql: expected a Template, got " + reflect.TypeOf(maybeQuery).String()),
-				})
-			}
-			sql, subSqlParams, err := query.Generate(params...)
+	// the builtin types are formatted without reflection, the named types of the same kinds fall back to it below
+	switch value := value.(type) {
+	case nil:
+		return "NULL", nil
+	case bool:
+		return quoteBool(value), nil
+	case int:
+		return strconv.Itoa(value), nil
+	case int64:
+		return strconv.FormatInt(value, 10), nil
+	case float64:
+		return quoteFloat(value, 64, floatFormat, floatPrecision)
+	case string:
+		return quoteString(value, dialect)
+	case time.Time:
+		literal := "'" + value.UTC().Format("2006-01-02 15:04:05.999999")
+		if dialect == DialectPostgres {
+			literal += "+00"
+		}
+		return literal + "'", nil
+	case []byte:
+		return "X'" + hex.EncodeToString(value) + "'", nil
+	case big.Int:
+		return quoteLiteral(&value, dialect, floatFormat, floatPrecision)
+	case big.Float:
+		return quoteLiteral(&value, dialect, floatFormat, floatPrecision)
+	case big.Rat:
+		return quoteLiteral(&value, dialect, floatFormat, floatPrecision)
+	case *big.Int, *big.Float, *big.Rat:
+		return QuoteDecimal(value, -1)
+	}
+	reflectedValue := reflect.ValueOf(value)
+	switch reflectedValue.Kind() {
+	case reflect.Pointer:
+		if reflectedValue.IsNil() {
+			return "NULL", nil
+		}
+		return quoteLiteral(reflectedValue.Elem().Interface(), dialect, floatFormat, floatPrecision)
+	case reflect.Bool:
+		return quoteBool(reflectedValue.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(reflectedValue.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(reflectedValue.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return quoteFloat(reflectedValue.Float(), reflectedValue.Type().Bits(), floatFormat, floatPrecision)
+	case reflect.String:
+		return quoteString(reflectedValue.String(), dialect)
+	case reflect.Slice, reflect.Array:
+		if reflectedValue.Kind() == reflect.Slice && reflectedValue.Type().Elem().Kind() == reflect.Uint8 {
+			return "X'" + hex.EncodeToString(reflectedValue.Bytes()) + "'", nil
+		}
+		// an empty IN () is invalid SQL, (NULL) matches no row like the empty list of param
+		if reflectedValue.Len() == 0 {
+			return "(NULL)", nil
+		}
+		elements := make([]string, reflectedValue.Len())
+		for i := range elements {
+			element, err := quoteLiteral(reflectedValue.Index(i).Interface(), dialect, floatFormat, floatPrecision)
 			if err != nil {
-				panic(template.ExecError{
-					Err: err,
-				})
+				return "", err
 			}
-			*sqlParams = append(*sqlParams, subSqlParams...)
-			return sql
-		},
-	})
-
-	var buf bytes.Buffer
-	templateData := any(nil)
-	if len(data) > 0 {
-		templateData = data[0]
-	}
-	if err := sqlTemplate.Execute(&buf, templateData); err != nil {
-		log.Error("error executing template", "error", err)
-		return "", nil, errors.Join(ErrPreparingQuery, err)
+			elements[i] = element
+		}
+		return "(" + strings.Join(elements, ", ") + ")", nil
+	case reflect.Map, reflect.Struct:
+		if reflectedValue.Kind() == reflect.Struct && isScalarStruct(reflectedValue.Type()) {
+			break
+		}
+		// json.Marshal reports a cyclic value as an error
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			log.Error("value can't be encoded as a JSON literal", "type", fmt.Sprintf("%T", value), "error", err)
+			return "", errors.Join(fmt.Errorf("%w: %T can't be encoded as JSON", ErrInvalidArgument, value), err)
+		}
+		return quoteLiteral(string(encoded), dialect, floatFormat, floatPrecision)
 	}
-	return buf.String(), *sqlParams, nil
+	log.Error("value can't be formatted as a literal", "type", fmt.Sprintf("%T", value))
+	return "", fmt.Errorf("%w: %T can't be formatted as a literal", ErrInvalidArgument, value)
 }
 
-// MustGenerate generates the SQL template with the given data and returns the generated SQL string.
-// It panics if an error occurs.
+// quoteBool formats a bool as an SQL literal
 //
 // Parameters:
-//   - query: The QueryTemplate to generate. Must not be nil.
-//   - data: Optional variadic parameters to pass to the query execution
+//   - value: The bool to format
 //
 // Returns:
-//   - string: The generated SQL string or an empty string if the template execution fails
-func MustGenerate[T any](sqlTemplate *template.Template, data ...any) (string, []any) {
-	sql, params, err := Generate[T](sqlTemplate, data...)
-	if err != nil {
-		panic(err)
+//   - string: TRUE or FALSE
+func quoteBool(value bool) string {
+	if value {
+		return "TRUE"
 	}
-	return sql, params
+	return "FALSE"
 }
 
-// PrepareContext prepares a QueryTemplate with the given context, database connection, and optional template data.
-// It returns a prepared statement and any error that occurred.
-// NOTE: Like Go Stmt, the prepared statement is invalidated once the transaction is committed or rolled back. You are responsible for closing the statement or re-preparing it.
-//
-// The type parameter T specifies the result type, which must be a struct. See New[S] for more details.
-// The type parameter Q must be either *sql.DB or *sql.Tx.
+// quoteFloat formats a float as an SQL literal like strconv.FormatFloat
 //
 // Parameters:
-//   - query: The QueryTemplate to prepare. Must not be nil.
-//   - ctx: The context for the query preparation. Used for cancellation and timeouts.
-//   - txOrDb: Database connection, can be either *sql.DB or *sql.Tx
-//   - data: Optional variadic parameters to pass to the query execution
+//   - float: The float to format
+//   - bits: The size of the float, 32 or 64
+//   - floatFormat: The strconv.FormatFloat format
+//   - floatPrecision: The strconv.FormatFloat precision
 //
 // Returns:
-//   - *QueryStmt[T]: A prepared statement
-//   - error: If query preparation fails
-func PrepareContext[T any, Q DbOrTx](query *QueryTemplate[T], ctx context.Context, txOrDb Q, data ...any) (*QueryStmt[T], error) {
-	// make sure the query is not nil
-	if query == nil {
-		log.ErrorContext(ctx, "Prepare called on a nil query")
-		return nil, errors.Join(ErrPreparingQuery, ErrNilQuery)
-	}
-	if query.template == nil {
-		// this should never happen but just in case we will check it anyway
-		log.ErrorContext(ctx, "Prepare called with a nil template")
-		return nil, errors.Join(ErrPreparingQuery, ErrNilTemplate)
-	}
-	if txOrDb == nil {
-		log.ErrorContext(ctx, "Prepare called with a nil tx or db")
-		return nil, errors.Join(ErrPreparingQuery, ErrPreparingQuery)
-	}
-	template, err := query.template.Clone()
-	if err != nil {
-		log.ErrorContext(ctx, "Error cloning template", "error", err)
-		return nil, errors.Join(ErrPreparingQuery, err)
-	}
-	generatedSQL, sqlParams, err := Generate[T](template, data...)
-	if err != nil {
-		log.ErrorContext(ctx, "Error parsing sql template", "error", err)
-		return nil, errors.Join(ErrPreparingQuery, err)
-	}
-	transformedSQL, indices := Parse[T](generatedSQL)
-	var stmt *sql.Stmt
-	switch db := any(txOrDb).(type) {
-	case *sql.DB:
-		stmt, err = db.PrepareContext(ctx, transformedSQL)
-	case *sql.Tx:
-		stmt, err = db.PrepareContext(ctx, transformedSQL)
-	default:
-		log.ErrorContext(ctx, "Prepare called with an invalid queryable", "error", ErrPreparingQuery)
-		return nil, errors.Join(ErrPreparingQuery, ErrInvalidQueryable)
-	}
-	if err != nil {
-		log.ErrorContext(ctx, "failed to prepare query", "error", err)
-		return nil, errors.Join(ErrPreparingQuery, err)
+//   - string: The literal
+//   - error: ErrInvalidArgument if the float is a NaN or infinite
+func quoteFloat(float float64, bits int, floatFormat byte, floatPrecision int) (string, error) {
+	if math.IsNaN(float) || math.IsInf(float, 0) {
+		log.Error("float can't be formatted as a literal", "value", float)
+		return "", fmt.Errorf("%w: %v has no SQL literal", ErrInvalidArgument, float)
 	}
-	queryStmt := &QueryStmt[T]{template: query, indices: indices, SQL: transformedSQL, prepared: stmt, sqlParams: sqlParams}
-
-	return queryStmt, nil
+	return strconv.FormatFloat(float, floatFormat, floatPrecision, bits), nil
 }
 
-// Prepare prepares a QueryTemplate with the given database connection and optional template data.
-// It returns a prepared statement and any error that occurred.
-//
-// The type parameter T specifies the result type, which must be a struct. See New[S] for more details.
-// The type parameter Q must be either *sql.DB or *sql.Tx.
+// quoteString formats a string as a quoted SQL literal for the dialect, a backslash is only escaped in MySQL and a
+// postgres string with control characters is an escape string
 //
 // Parameters:
-//   - query: The QueryTemplate to prepare. Must not be nil.
-//   - db: Database connection, can be either *sql.DB or *sql.Tx
-//   - data: Optional variadic parameters to pass to the query execution
+//   - literal: The string to format
+//   - dialect: The dialect
 //
 // Returns:
-//   - *QueryStmt[T]: A prepared statement
-//   - error: If query preparation fails
-func Prepare[T any, Q DbOrTx](tqlQuery *QueryTemplate[T], db Q, data ...any) (*QueryStmt[T], error) {
-	return PrepareContext(tqlQuery, context.Background(), db, data...)
+//   - string: The literal
+//   - error: ErrInvalidArgument if the string contains a NUL character
+func quoteString(literal string, dialect Dialect) (string, error) {
+	if strings.ContainsRune(literal, 0) {
+		log.Error("value can't be formatted as a literal", "type", "string")
+		return "", fmt.Errorf("%w: a string with a NUL character can't be formatted as a literal", ErrInvalidArgument)
+	}
+	if dialect == DialectPostgres && strings.ContainsFunc(literal, unicode.IsControl) {
+		return quoteEscapeString(literal), nil
+	}
+	if dialect == DialectMySQL {
+		literal = strings.ReplaceAll(literal, `\`, `\\`)
+	}
+	return "'" + strings.ReplaceAll(literal, "'", "''") + "'", nil
 }
 
-// Parse parses the SQL string and extracts field information for scanning
+// quoteEscapeString formats a string with control characters as a postgres escape string, e.g. E'a\nb', so the
+// control characters are readable in the SQL
+//
+// Parameters:
+//   - literal: The string to format
+//
+// Returns:
+//   - string: The escape string literal
+func quoteEscapeString(literal string) string {
+	var escaped strings.Builder
+	escaped.WriteString("E'")
+	for _, char := range literal {
+		switch char {
+		case '\\':
+			escaped.WriteString(`\\`)
+		case '\'':
+			escaped.WriteString("''")
+		case '\n':
+			escaped.WriteString(`\n`)
+		case '\r':
+			escaped.WriteString(`\r`)
+		case '\t':
+			escaped.WriteString(`\t`)
+		case '\b':
+			escaped.WriteString(`\b`)
+		case '\f':
+			escaped.WriteString(`\f`)
+		default:
+			if unicode.IsControl(char) {
+				fmt.Fprintf(&escaped, `\u%04X`, char)
+				continue
+			}
+			escaped.WriteRune(char)
+		}
+	}
+	escaped.WriteString("'")
+	return escaped.String()
+}
+
+// QuoteRune formats a rune as a single character string literal for the dialect, e.g. 'A' for a grade, where
+// QuoteLiteral formats it as its number since a rune is an int32.
+//
+// Example usage:
+//
+//	literal, err := QuoteRune('A', DialectMySQL) // 'A'
+//
+// Parameters:
+//   - char: The character to format
+//   - dialect: The dialect, see QuoteLiteral
+//
+// Returns:
+//   - string: The literal
+//   - error: ErrInvalidArgument if the rune is not a valid character or is NUL
+func QuoteRune(char rune, dialect Dialect) (string, error) {
+	if !utf8.ValidRune(char) {
+		log.Error("rune can't be formatted as a literal", "rune", char)
+		return "", fmt.Errorf("%w: %U is not a valid character", ErrInvalidArgument, char)
+	}
+	return QuoteLiteral(string(char), dialect)
+}
+
+// QuoteDecimal formats a *big.Int, *big.Float or *big.Rat as an unquoted numeric literal without going through a
+// float64, e.g. for money. The precision is the number of digits after the decimal point, the value is rounded to it.
+//
+// Example usage:
+//
+//	literal, err := QuoteDecimal(big.NewRat(1, 3), 4) // 0.3333
+//
+// Parameters:
+//   - value: The number to format, a nil pointer is NULL
+//   - precision: The number of digits after the decimal point, a negative precision formats a *big.Float with the
+//     digits needed to represent it uniquely and a *big.Rat with the digits of its exact decimal
+//
+// Returns:
+//   - string: The literal
+//   - error: ErrInvalidArgument if the value is not a big number, is infinite or is a *big.Rat without an exact
+//     decimal and a negative precision
+func QuoteDecimal(value any, precision int) (string, error) {
+	switch value := value.(type) {
+	case *big.Int:
+		if value == nil {
+			return "NULL", nil
+		}
+		if precision <= 0 {
+			return value.String(), nil
+		}
+		return value.String() + "." + strings.Repeat("0", precision), nil
+	case *big.Float:
+		if value == nil {
+			return "NULL", nil
+		}
+		if value.IsInf() {
+			break
+		}
+		return value.Text('f', precision), nil
+	case *big.Rat:
+		if value == nil {
+			return "NULL", nil
+		}
+		if precision < 0 {
+			// a rational such as 1/3 has no exact decimal, it must be rounded to a precision
+			digits, exact := value.FloatPrec()
+			if !exact {
+				log.Error("rational can't be formatted as an exact decimal", "value", value.String())
+				return "", fmt.Errorf("%w: %s has no exact decimal, pass a precision", ErrInvalidArgument, value.String())
+			}
+			precision = digits
+		}
+		return value.FloatString(precision), nil
+	}
+	log.Error("value can't be formatted as a decimal literal", "type", fmt.Sprintf("%T", value))
+	return "", fmt.Errorf("%w: %T can't be formatted as a decimal literal", ErrInvalidArgument, value)
+}
+
+// EscapeLike escapes the LIKE wildcards % and _ and the \ escape character so a user supplied substring is matched
+// literally. The result is meant to be bound as a param and concatenated with the wildcards in SQL,
+// it is also available to the templates as the like function.
+//
+// Example usage:
+//
+//	query := Must[User](`SELECT * FROM User WHERE User.name LIKE CONCAT('%', {{ param (like .Name) }}, '%')`)
+//
+// Parameters:
+//   - value: The value to escape
+//
+// Returns:
+//   - string: The escaped value
+func EscapeLike(value string) string {
+	return likeReplacer.Replace(value)
+}
+
+// Query executes a QueryTemplate with the given database connection and optional template data.
+// It returns a slice of results of type T and any error that occurred.
+// It uses context.Background(), use QueryContext to pass the context of the request to the driver.
+//
+// The type parameter T specifies the result type, which must be a struct. See New[T] for more details.
+// The type parameter Q can be any Preparer such as *sql.DB, *sql.Tx or *sql.Conn.
+//
+// Parameters:
+//   - query: The QueryTemplate to execute. Must not be nil.
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - []T: A slice of results of type T
+//   - error: If query preparation or execution fails
+func Query[T any, Q Preparer](query *QueryTemplate[T], db Q, data ...any) ([]T, error) {
+	return QueryContext(query, context.Background(), db, data...)
+}
+
+// QueryContext executes a QueryTemplate with the given context, database connection, and optional template data.
+// It returns a slice of results of type T and any error that occurred.
+//
+// The type parameter T specifies the result type, which must be a struct. See New[S] for more details.
+// The type parameter Q can be any Preparer such as *sql.DB, *sql.Tx or *sql.Conn.
+//
+// Parameters:
+//   - query: The QueryTemplate to execute. Must not be nil.
+//   - ctx: The context for the query execution. Used for cancellation and timeouts.
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - []T: A slice of results of type T
+//   - error: If query preparation or execution fails
+func QueryContext[T any, Q Preparer](query *QueryTemplate[T], ctx context.Context, txOrDb Q, data ...any) ([]T, error) {
+	results := []T{}
+	if query == nil {
+		log.ErrorContext(ctx, "Execute called on a nil query", "error", ErrNilQuery)
+		return results, errors.Join(ErrExecutingQuery, ErrNilQuery)
+	}
+	var err error
+	stmt, err := PrepareContext(query, ctx, txOrDb)
+	if err != nil {
+		return results, errors.Join(ErrExecutingQuery, err)
+	}
+	defer stmt.Close()
+	return stmt.QueryContext(ctx, data...)
+}
+
+// MustQuery executes a QueryTemplate like Query and panics if an error occurs.
+// This is a convenience for tests, fixtures and bootstrap code, otherwise use Query to handle errors gracefully.
+//
+// Example usage:
+//
+//	users := MustQuery(usersQuery, db)
+//
+// Parameters:
+//   - query: The QueryTemplate to execute. Must not be nil.
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - []T: A slice of results of type T
+func MustQuery[T any, Q Preparer](query *QueryTemplate[T], db Q, data ...any) []T {
+	results, err := Query(query, db, data...)
+	if err != nil {
+		panic(err)
+	}
+	return results
+}
+
+// First executes a QueryTemplate with the given database connection and returns the first row.
+// Unlike One, finding no rows is not an error, which is useful for lookups that fall back to a default.
+// See FirstContext for more details.
+//
+// Parameters:
+//   - query: The QueryTemplate to execute. Must not be nil.
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - T: The first row or the zero value if no rows matched
+//   - bool: True if a row was found, false otherwise
+//   - error: If query preparation or execution fails
+func First[T any, Q Preparer](query *QueryTemplate[T], db Q, data ...any) (T, bool, error) {
+	return FirstContext(query, context.Background(), db, data...)
+}
+
+// FirstContext executes a QueryTemplate with the given context and database connection and returns the first row.
+// Only the first row is scanned, the rest of the result set is not read.
+//
+// Example usage:
+//
+//	login, found, err := FirstContext(lastLoginQuery, ctx, db, userId)
+//
+// Parameters:
+//   - query: The QueryTemplate to execute. Must not be nil.
+//   - ctx: The context for the query execution. Used for cancellation and timeouts.
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - T: The first row or the zero value if no rows matched
+//   - bool: True if a row was found, false otherwise
+//   - error: If query preparation or execution fails
+func FirstContext[T any, Q Preparer](query *QueryTemplate[T], ctx context.Context, db Q, data ...any) (T, bool, error) {
+	var result T
+	results, err := queryLimit(query, ctx, db, 1, data...)
+	if err != nil || len(results) == 0 {
+		return result, false, err
+	}
+	return results[0], true, nil
+}
+
+// One executes a QueryTemplate with the given database connection and returns exactly one row.
+// See OneContext for more details.
+//
+// Parameters:
+//   - query: The QueryTemplate to execute. Must not be nil.
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - T: The row
+//   - error: ErrNoRows if no rows matched, ErrMultipleRows if more than one row matched or if query preparation or execution fails
+func One[T any, Q Preparer](query *QueryTemplate[T], db Q, data ...any) (T, error) {
+	return OneContext(query, context.Background(), db, data...)
+}
+
+// OneContext executes a QueryTemplate with the given context and database connection and returns exactly one row.
+// This is meant for lookups such as fetching by primary key, where a duplicate row is a hard error.
+// At most two rows are scanned to detect duplicates, the rest of the result set is not read.
+//
+// Example usage:
+//
+//	user, err := OneContext(userByIdQuery, ctx, db, userId)
+//	if errors.Is(err, ErrNoRows) {
+//	    // not found
+//	}
+//
+// Parameters:
+//   - query: The QueryTemplate to execute. Must not be nil.
+//   - ctx: The context for the query execution. Used for cancellation and timeouts.
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - T: The row
+//   - error: ErrNoRows if no rows matched, ErrMultipleRows if more than one row matched or if query preparation or execution fails
+func OneContext[T any, Q Preparer](query *QueryTemplate[T], ctx context.Context, db Q, data ...any) (T, error) {
+	var result T
+	results, err := queryLimit(query, ctx, db, 2, data...)
+	if err != nil {
+		return result, err
+	}
+	switch len(results) {
+	case 0:
+		return result, ErrNoRows
+	case 1:
+		return results[0], nil
+	default:
+		log.ErrorContext(ctx, "expected one row", "error", ErrMultipleRows)
+		return result, ErrMultipleRows
+	}
+}
+
+// queryLimit prepares a QueryTemplate, scans at most limit rows and closes the statement
+//
+// Parameters:
+//   - query: The QueryTemplate to execute. Must not be nil.
+//   - ctx: The context for the query execution. Used for cancellation and timeouts.
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - limit: The maximum number of rows to scan
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - []T: A slice of at most limit results of type T
+//   - error: If query preparation or execution fails
+func queryLimit[T any, Q Preparer](query *QueryTemplate[T], ctx context.Context, db Q, limit int, data ...any) ([]T, error) {
+	if query == nil {
+		log.ErrorContext(ctx, "Execute called on a nil query", "error", ErrNilQuery)
+		return nil, errors.Join(ErrExecutingQuery, ErrNilQuery)
+	}
+	stmt, err := PrepareContext(query, ctx, db)
+	if err != nil {
+		return nil, errors.Join(ErrExecutingQuery, err)
+	}
+	defer stmt.Close()
+	return stmt.scan(ctx, limit, data...)
+}
+
+// ExecContext executes a QueryTemplate with the given context, database connection, and optional template data.
+// It returns the result of the query execution and any error that occurred.
+//
+// The type parameter T specifies the result type, which must be a struct. See New[S] for more details.
+// The type parameter Q can be any Preparer such as *sql.DB, *sql.Tx or *sql.Conn.
+//
+// Parameters:
+//   - query: The QueryTemplate to execute. Must not be nil.
+//   - ctx: The context for the query execution. Used for cancellation and timeouts.
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - sql.Result containing the execution results
+//   - error if query preparation or execution fails
+func ExecContext[T any, Q Preparer](query *QueryTemplate[T], ctx context.Context, db Q, data ...any) (sql.Result, error) {
+	if query == nil {
+		log.ErrorContext(ctx, "Execute called on a nil query", "error", ErrNilQuery)
+		return nil, errors.Join(ErrExecutingQuery, ErrNilQuery)
+	}
+	stmt, err := PrepareContext(query, ctx, db)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to prepare query", "error", err)
+		return nil, errors.Join(ErrExecutingQuery, err)
+	}
+	defer stmt.Close()
+	return stmt.ExecContext(ctx, data...)
+}
+
+// Exec executes a QueryTemplate with the given database connection and optional template data.
+// It returns the result of the query execution and any error that occurred.
+// It uses context.Background(), use ExecContext to pass the context of the request to the driver.
+//
+// The type parameter T specifies the result type, which must be a struct. See New[S] for more details.
+// The type parameter Q can be any Preparer such as *sql.DB, *sql.Tx or *sql.Conn.
+//
+// Parameters:
+//   - query: The QueryTemplate to execute. Must not be nil.
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - sql.Result containing the execution results
+//   - error if query preparation or execution fails
+func Exec[T any, Q Preparer](query *QueryTemplate[T], db Q, data ...any) (sql.Result, error) {
+	return ExecContext(query, context.Background(), db, data...)
+}
+
+// MustExec executes a QueryTemplate like Exec and panics if an error occurs.
+// This is a convenience for tests, fixtures and seed scripts, otherwise use Exec to handle errors gracefully.
+//
+// Example usage:
+//
+//	MustExec(insertUserQuery, db, 1, "John Doe")
+//
+// Parameters:
+//   - query: The QueryTemplate to execute. Must not be nil.
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - sql.Result: The result of the query execution
+func MustExec[T any, Q Preparer](query *QueryTemplate[T], db Q, data ...any) sql.Result {
+	result, err := Exec(query, db, data...)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+// ExecReturningContext executes an INSERT, UPDATE or DELETE statement with a RETURNING clause and scans the returned
+// rows into T. The RETURNING projection is parsed like the projection of a SELECT statement, see Parse for more details.
+// This is needed on Postgres where the sql.Result of Exec doesn't carry the generated values.
+//
+// Example usage:
+//
+//	users, err := ExecReturningContext(Must[User](`INSERT INTO users (name) VALUES (?) RETURNING id, created_at`), ctx, db, "John Doe")
+//
+// Parameters:
+//   - query: The QueryTemplate to execute. Must not be nil.
+//   - ctx: The context for the query execution. Used for cancellation and timeouts.
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - []T: The returned rows
+//   - error: If query preparation or execution fails
+func ExecReturningContext[T any, Q Preparer](query *QueryTemplate[T], ctx context.Context, db Q, data ...any) ([]T, error) {
+	return QueryContext(query, ctx, db, data...)
+}
+
+// ExecReturning executes an INSERT, UPDATE or DELETE statement with a RETURNING clause and scans the returned rows into T.
+// See ExecReturningContext for more details.
+//
+// Parameters:
+//   - query: The QueryTemplate to execute. Must not be nil.
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - []T: The returned rows
+//   - error: If query preparation or execution fails
+func ExecReturning[T any, Q Preparer](query *QueryTemplate[T], db Q, data ...any) ([]T, error) {
+	return ExecReturningContext(query, context.Background(), db, data...)
+}
+
+// Generate generates the SQL template with the given data and returns the generated SQL string and any error that occurred.
+// The params are collected in the order the param and tql functions are executed, which is their position in the
+// generated SQL. The order never depends on the iteration order of a Params map, and a range over a map iterates
+// its keys in sorted order, so the same data always generates the same SQL and params.
+// The named function binds a param by name instead, e.g. {{ named "id" .Id }} generates an @id placeholder bound to
+// sql.Named("id", .Id), and a name referenced more than once is only bound once so the order of the branches doesn't
+// matter. Named params require a driver that supports sql.NamedArg and shouldn't be mixed with positional params.
+//
+// Parameters:
+//   - query: The QueryTemplate to generate. Must not be nil.
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - string: The generated SQL string
+//   - []any: The params in placeholder order
+//   - error: If the template execution fails
+func Generate[T any](sqlTemplate *template.Template, data ...any) (string, []any, error) {
+	sql, params, _, err := generate[T](sqlTemplate, newOptions(), data...)
+	return sql, params, err
+}
+
+// generate generates the SQL template with the placeholders of the dialect, see Generate for more details
+//
+// Parameters:
+//   - sqlTemplate: The template to generate. Must not be nil.
+//   - opts: The options of the query, such as the dialect of the placeholders
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - string: The generated SQL string
+//   - []any: The params in placeholder order
+//   - []string: The template path of each param, empty if the param was not bound from a field or variable
+//   - error: If the template execution fails
+func generate[T any](sqlTemplate *template.Template, opts options, data ...any) (string, []any, []string, error) {
+	if sqlTemplate == nil {
+		log.Error("Generate called on a nil query")
+		return "", nil, nil, ErrNilQuery
+	}
+	dialect := opts.dialect
+	// using a pointer to the sqlParams map here so we can instantiate it in place if it is nil
+	sqlParams := &[]any{}
+	paramPaths := &[]string{}
+	placeholder := func(path string, value any) string {
+		*sqlParams = append(*sqlParams, value)
+		*paramPaths = append(*paramPaths, path)
+		if dialect == DialectPostgres {
+			return "$" + strconv.Itoa(len(*sqlParams))
+		}
+		return "?"
+	}
+	param := func(path string, value any) string {
+		if value != nil && reflect.TypeOf(value).Kind() == reflect.Slice {
+			v := reflect.ValueOf(value)
+			// a nil slice is an empty list like it is for len and range
+			if v.Len() == 0 {
+				return emptyList(opts.emptyList, path)
+			}
+			placeholders := make([]string, v.Len())
+			for i := 0; i < v.Len(); i++ {
+				elementPath := ""
+				if path != "" {
+					elementPath = path + "[" + strconv.Itoa(i) + "]"
+				}
+				placeholders[i] = placeholder(elementPath, v.Index(i).Interface())
+			}
+			return "(" + strings.Join(placeholders, ",") + ")"
+		}
+		return placeholder(path, value)
+	}
+	// bound holds the placeholders of the params annotated with their path, see annotateParamPaths
+	bound := map[string]struct {
+		value       any
+		placeholder string
+	}{}
+	// named holds the values of the params bound by name
+	named := map[string]any{}
+	sqlTemplate.Funcs(template.FuncMap{
+		"param": func(value any) string {
+			return param("", value)
+		},
+		"paramAt": func(path string, value any) string {
+			// the same path can hold another value, e.g. .Id inside a range, so the value is compared too
+			if prior, ok := bound[path]; ok && dialect == DialectPostgres && reflect.DeepEqual(prior.value, value) {
+				return prior.placeholder
+			}
+			generated := param(path, value)
+			bound[path] = struct {
+				value       any
+				placeholder string
+			}{value, generated}
+			return generated
+		},
+		"named": func(name string, value any) string {
+			if !identRegex.MatchString(name) || strings.Contains(name, ".") {
+				panic(template.ExecError{
+					Err: errors.Join(ErrInvalidIdentifier, errors.New("named: invalid param name "+name)),
+				})
+			}
+			// a repeated name is bound once, the driver resolves every reference to the same sql.NamedArg
+			if prior, ok := named[name]; ok {
+				if !reflect.DeepEqual(prior, value) {
+					panic(template.ExecError{
+						Err: errors.New("named: @" + name + " is bound to different values"),
+					})
+				}
+				return "@" + name
+			}
+			named[name] = value
+			*sqlParams = append(*sqlParams, sql.Named(name, value))
+			*paramPaths = append(*paramPaths, "@"+name)
+			return "@" + name
+		},
+		"lit": func(value any) string {
+			literal, err := quoteLiteral(value, dialect, opts.floatFormat, opts.floatPrecision)
+			if err != nil {
+				panic(template.ExecError{Err: err})
+			}
+			return literal
+		},
+		"ident": func(name string) string {
+			ident, err := QuoteIdent(name, dialect)
+			if err != nil {
+				panic(template.ExecError{Err: err})
+			}
+			return ident
+		},
+		"tql": func(maybeQuery any, params ...any) any {
+			query, ok := maybeQuery.(Template)
+			if !ok {
+				panic(template.ExecError{
+					Err: errors.New("tql: expected a Template, got " + reflect.TypeOf(maybeQuery).String()),
+				})
+			}
+			var sql string
+			var subSqlParams []any
+			var err error
+			if subQuery, ok := query.(dialectTemplate); ok {
+				// the subquery placeholders follow the dialect of the outer query
+				sql, subSqlParams, err = subQuery.generateDialect(dialect, params...)
+			} else {
+				sql, subSqlParams, err = query.Generate(params...)
+			}
+			if err != nil {
+				panic(template.ExecError{
+					Err: err,
+				})
+			}
+			if dialect == DialectPostgres {
+				// the ordinals of the subquery start at $1 so they are shifted past the params bound so far
+				offset := len(*sqlParams)
+				sql = ordinalRegex.ReplaceAllStringFunc(sql, func(ordinal string) string {
+					n, _ := strconv.Atoi(ordinal[1:])
+					return "$" + strconv.Itoa(n+offset)
+				})
+			}
+			*sqlParams = append(*sqlParams, subSqlParams...)
+			*paramPaths = append(*paramPaths, make([]string, len(subSqlParams))...)
+			return sql
+		},
+	})
+
+	var buf bytes.Buffer
+	templateData := any(nil)
+	if len(data) > 0 {
+		templateData = data[0]
+	}
+	if err := sqlTemplate.Execute(&buf, templateData); err != nil {
+		log.Error("error executing template", "error", err)
+		return "", nil, nil, errors.Join(ErrPreparingQuery, err)
+	}
+	sql, err := checkInterpolations(buf.String(), dialect)
+	if err != nil {
+		return "", nil, nil, errors.Join(ErrPreparingQuery, err)
+	}
+	return sql, *sqlParams, *paramPaths, nil
+}
+
+// emptyList returns the list an empty slice param expands to, IN () is not valid SQL, see WithEmptyList
+//
+// Parameters:
+//   - emptyList: How an empty list is generated
+//   - path: The template path of the param, empty if the param was not bound from a field or variable
+//
+// Returns:
+//   - string: The list, it panics with ErrEmptyList if empty lists are rejected
+func emptyList(emptyList EmptyList, path string) string {
+	switch emptyList {
+	case EmptyListNoRows:
+		return "(SELECT NULL WHERE 1 = 0)"
+	case EmptyListError:
+		if path == "" {
+			path = "param"
+		}
+		panic(template.ExecError{
+			Err: fmt.Errorf("%w: %s", ErrEmptyList, path),
+		})
+	}
+	return "(NULL)"
+}
+
+// markActions surrounds the output of every template action with the actionStart and actionEnd markers so
+// checkInterpolations can tell where the actions expanded, see WithStrictParams
+//
+// Parameters:
+//   - node: The node of the parse tree to rewrite
+func markActions(node parsetree.Node) {
+	switch node := node.(type) {
+	case *parsetree.ListNode:
+		if node == nil {
+			return
+		}
+		nodes := make([]parsetree.Node, 0, len(node.Nodes))
+		for _, child := range node.Nodes {
+			action, ok := child.(*parsetree.ActionNode)
+			// a declaration such as {{ $id := .Id }} has no output
+			if !ok || len(action.Pipe.Decl) > 0 {
+				markActions(child)
+				nodes = append(nodes, child)
+				continue
+			}
+			nodes = append(nodes,
+				&parsetree.TextNode{NodeType: parsetree.NodeText, Pos: action.Pos, Text: []byte(actionStart)},
+				action,
+				&parsetree.TextNode{NodeType: parsetree.NodeText, Pos: action.Pos, Text: []byte(actionEnd)},
+			)
+		}
+		node.Nodes = nodes
+	case *parsetree.IfNode:
+		markActions(&node.BranchNode)
+	case *parsetree.RangeNode:
+		markActions(&node.BranchNode)
+	case *parsetree.WithNode:
+		markActions(&node.BranchNode)
+	case *parsetree.BranchNode:
+		markActions(node.List)
+		markActions(node.ElseList)
+	}
+}
+
+// checkInterpolations removes the markers added by markActions and checks that no action expanded inside a quoted
+// string literal. The quotes are tracked in the template text only, a quote in the output of an action is ignored.
+//
+// Parameters:
+//   - sql: The generated SQL
+//   - dialect: The dialect, a double quote is a string literal and a backslash escapes a quote only in MySQL
+//
+// Returns:
+//   - string: The generated SQL without the markers
+//   - error: ErrInterpolatedValue if an action expanded inside a quoted string literal
+func checkInterpolations(sql string, dialect Dialect) (string, error) {
+	if !strings.Contains(sql, actionStart) {
+		return sql, nil
+	}
+	var cleaned strings.Builder
+	// quote is the quote of the string or identifier, or - and * for a line or block comment
+	var quote byte
+	for i := 0; i < len(sql); {
+		if strings.HasPrefix(sql[i:], actionStart) {
+			end := strings.Index(sql[i:], actionEnd)
+			if quote == '\'' || (quote == '"' && dialect == DialectMySQL) {
+				near := cleaned.String()[max(0, cleaned.Len()-20):]
+				log.Error("template action expanded inside a string literal", "near", near)
+				return "", fmt.Errorf("%w: the action after %q is inside a string literal, bind the value with param instead", ErrInterpolatedValue, near)
+			}
+			cleaned.WriteString(sql[i+len(actionStart) : i+end])
+			i += end + len(actionEnd)
+			continue
+		}
+		char := sql[i]
+		switch {
+		case quote == '-':
+			if char == '\n' {
+				quote = 0
+			}
+		case quote == '*':
+			if strings.HasPrefix(sql[i:], "*/") {
+				cleaned.WriteByte(char)
+				i++
+				char = sql[i]
+				quote = 0
+			}
+		case quote != 0:
+			if char == '\\' && dialect == DialectMySQL && i+1 < len(sql) {
+				cleaned.WriteByte(char)
+				i++
+				char = sql[i]
+			} else if char == quote {
+				quote = 0
+			}
+		case char == '\'' || char == '"' || char == '`':
+			quote = char
+		case strings.HasPrefix(sql[i:], "--"):
+			quote = '-'
+		case strings.HasPrefix(sql[i:], "/*"):
+			quote = '*'
+		}
+		cleaned.WriteByte(char)
+		i++
+	}
+	return cleaned.String(), nil
+}
+
+// annotateParamPaths rewrites the param calls on a field or variable, e.g. {{ param .Id }}, into paramAt calls that
+// also receive the path so binding errors can name the param and a repeated param can reuse its ordinal placeholder
+//
+// Parameters:
+//   - node: The node of the parse tree to rewrite
+func annotateParamPaths(node parsetree.Node) {
+	switch node := node.(type) {
+	case *parsetree.ListNode:
+		if node == nil {
+			return
+		}
+		for _, child := range node.Nodes {
+			annotateParamPaths(child)
+		}
+	case *parsetree.ActionNode:
+		annotateParamPaths(node.Pipe)
+	case *parsetree.IfNode:
+		annotateParamPaths(&node.BranchNode)
+	case *parsetree.RangeNode:
+		annotateParamPaths(&node.BranchNode)
+	case *parsetree.WithNode:
+		annotateParamPaths(&node.BranchNode)
+	case *parsetree.BranchNode:
+		annotateParamPaths(node.Pipe)
+		annotateParamPaths(node.List)
+		annotateParamPaths(node.ElseList)
+	case *parsetree.TemplateNode:
+		annotateParamPaths(node.Pipe)
+	case *parsetree.PipeNode:
+		if node == nil {
+			return
+		}
+		for _, command := range node.Cmds {
+			annotateParamPaths(command)
+		}
+	case *parsetree.CommandNode:
+		for _, arg := range node.Args {
+			annotateParamPaths(arg)
+		}
+		if len(node.Args) != 2 {
+			return
+		}
+		ident, ok := node.Args[0].(*parsetree.IdentifierNode)
+		if !ok || ident.Ident != "param" {
+			return
+		}
+		switch node.Args[1].(type) {
+		case *parsetree.FieldNode, *parsetree.VariableNode, *parsetree.ChainNode:
+			path := node.Args[1].String()
+			ident.Ident = "paramAt"
+			node.Args = []parsetree.Node{ident, &parsetree.StringNode{NodeType: parsetree.NodeString, Pos: ident.Pos, Quoted: strconv.Quote(path), Text: path}, node.Args[1]}
+		}
+	}
+}
+
+// MustGenerate generates the SQL template with the given data and returns the generated SQL string.
+// It panics if an error occurs.
+//
+// Parameters:
+//   - query: The QueryTemplate to generate. Must not be nil.
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - string: The generated SQL string or an empty string if the template execution fails
+func MustGenerate[T any](sqlTemplate *template.Template, data ...any) (string, []any) {
+	sql, params, err := Generate[T](sqlTemplate, data...)
+	if err != nil {
+		panic(err)
+	}
+	return sql, params
+}
+
+// PrepareContext prepares a QueryTemplate with the given context, database connection, and optional template data.
+// It returns a prepared statement and any error that occurred.
+// NOTE: Like Go Stmt, the prepared statement is invalidated once the transaction is committed or rolled back. You are responsible for closing the statement or re-preparing it.
+// Executing an invalidated statement returns ErrStmtInvalidated, a Plan re-prepares the statement without generating
+// and parsing the template again.
+//
+// The type parameter T specifies the result type, which must be a struct. See New[S] for more details.
+// The type parameter Q can be any Preparer such as *sql.DB, *sql.Tx or *sql.Conn.
+//
+// Parameters:
+//   - query: The QueryTemplate to prepare. Must not be nil.
+//   - ctx: The context for the query preparation. Used for cancellation and timeouts.
+//   - txOrDb: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - *QueryStmt[T]: A prepared statement
+//   - error: If query preparation fails
+func PrepareContext[T any, Q Preparer](query *QueryTemplate[T], ctx context.Context, txOrDb Q, data ...any) (queryStmt *QueryStmt[T], err error) {
+	// make sure the query is not nil
+	if query == nil {
+		log.ErrorContext(ctx, "Prepare called on a nil query")
+		return nil, errors.Join(ErrPreparingQuery, ErrNilQuery)
+	}
+	if query.template == nil {
+		// this should never happen but just in case we will check it anyway
+		log.ErrorContext(ctx, "Prepare called with a nil template")
+		return nil, errors.Join(ErrPreparingQuery, ErrNilTemplate)
+	}
+	if isNil(txOrDb) {
+		log.ErrorContext(ctx, "Prepare called with a nil tx or db")
+		return nil, errors.Join(ErrPreparingQuery, ErrPreparingQuery)
+	}
+	ctx, span := startSpan(ctx, query.options, "tql.prepare")
+	defer func() { span.End(err) }()
+	plan, err := newPlan(ctx, query, data...)
+	if err != nil {
+		return nil, err
+	}
+	return plan.prepare(ctx, span, txOrDb)
+}
+
+// Prepare prepares a QueryTemplate with the given database connection and optional template data.
+// It returns a prepared statement and any error that occurred.
+// It uses context.Background(), use PrepareContext to pass the context of the request to the driver.
+//
+// The type parameter T specifies the result type, which must be a struct. See New[S] for more details.
+// The type parameter Q can be any Preparer such as *sql.DB, *sql.Tx or *sql.Conn.
+//
+// Parameters:
+//   - query: The QueryTemplate to prepare. Must not be nil.
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - *QueryStmt[T]: A prepared statement
+//   - error: If query preparation fails
+func Prepare[T any, Q Preparer](tqlQuery *QueryTemplate[T], db Q, data ...any) (*QueryStmt[T], error) {
+	return PrepareContext(tqlQuery, context.Background(), db, data...)
+}
+
+// MustPrepare prepares a QueryTemplate like Prepare and panics if an error occurs.
+// This is a convenience for tests and bootstrap code, otherwise use Prepare to handle errors gracefully.
+//
+// Example usage:
+//
+//	userByIdStmt := MustPrepare(userByIdQuery, db)
+//
+// Parameters:
+//   - query: The QueryTemplate to prepare. Must not be nil.
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - data: Optional variadic parameters to pass to the template execution
+//
+// Returns:
+//   - *QueryStmt[T]: A prepared statement
+func MustPrepare[T any, Q Preparer](query *QueryTemplate[T], db Q, data ...any) *QueryStmt[T] {
+	stmt, err := Prepare(query, db, data...)
+	if err != nil {
+		panic(err)
+	}
+	return stmt
+}
+
+// Explain generates and parses a QueryTemplate without preparing it against a database.
+// It returns the transformed SQL that would be prepared and the indices of the fields that would be scanned,
+// which is useful for debugging and golden-file tests of query templates.
+//
+// Example usage:
+//
+//	sql, indices, err := Explain(query, Params{"Id": 1})
+//
+// Parameters:
+//   - query: The QueryTemplate to explain. Must not be nil.
+//   - data: Optional variadic parameters to pass to the template execution
+//
+// Returns:
+//   - string: The transformed SQL string with placeholders
+//   - [][]int: The indices of the fields that are selected
+//   - error: If the template execution fails
+func Explain[T any](query *QueryTemplate[T], data ...any) (string, [][]int, error) {
+	transformedSQL, _, indices, err := generateAndParse(query, data...)
+	return transformedSQL, indices, err
+}
+
+// GenerateAndArgs generates and parses a QueryTemplate and returns the final SQL with the ordered args,
+// ready to pass to db.Query(sql, args...) for callers that execute queries with their own db layer.
+// The param function placeholders, including IN lists, are expanded and their values are collected in placeholder order.
+//
+// Example usage:
+//
+//	sql, args, err := GenerateAndArgs(query, Params{"Ids": []int{1, 2}, "Name": "John Doe"})
+//	rows, err := db.Query(sql, args...)
+//
+// Parameters:
+//   - query: The QueryTemplate to generate. Must not be nil.
+//   - data: Optional variadic parameters to pass to the template execution
+//
+// Returns:
+//   - string: The transformed SQL string with placeholders
+//   - []any: The args in placeholder order
+//   - error: If the template execution fails
+func GenerateAndArgs[T any](query *QueryTemplate[T], data ...any) (string, []any, error) {
+	transformedSQL, args, _, err := generateAndParse(query, data...)
+	return transformedSQL, args, err
+}
+
+// generateAndParse generates the SQL template and parses the generated SQL without a database
+//
+// Parameters:
+//   - query: The QueryTemplate to generate. Must not be nil.
+//   - data: Optional variadic parameters to pass to the template execution
+//
+// Returns:
+//   - string: The transformed SQL string with placeholders
+//   - []any: The args in placeholder order
+//   - [][]int: The indices of the fields that are selected
+//   - error: If the template execution fails
+func generateAndParse[T any](query *QueryTemplate[T], data ...any) (string, []any, [][]int, error) {
+	if query == nil {
+		log.Error("Generate called on a nil query")
+		return "", nil, nil, ErrNilQuery
+	}
+	if query.template == nil {
+		log.Error("Generate called with a nil template")
+		return "", nil, nil, ErrNilTemplate
+	}
+	plan, err := newPlan(context.Background(), query, data...)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return plan.sql, plan.sqlParams, plan.indices, nil
+}
+
+// Parse parses the SQL string and extracts field information for scanning.
+// The branches of a UNION are rewritten consistently, if their projections don't select the same fields
+// the SQL is returned unchanged without indices, use Explain to get the ErrUnsupportedUnion error.
+//
+// Parameters:
+//   - sql: The SQL string to parse
+//   - maybeOptions: Optional options such as WithTagNames
+//
+// Returns:
+//   - string: The parsed SQL string
+//   - [][]int: The indices of the fields that are selected
+func Parse[T any](sql string, maybeOptions ...Option) (string, [][]int) {
+	parsedSQL, indices, err := parse[T](sql, newOptions(maybeOptions...))
+	if err != nil {
+		return sql, nil
+	}
+	return parsedSQL, indices
+}
+
+// parse parses the SQL string with the given options, each branch of a UNION is parsed separately and must select
+// the same fields. See Parse for more details.
 //
 // Parameters:
 //   - sql: The SQL string to parse
+//   - opts: The options of the query
+//
+// Returns:
+//   - string: The parsed SQL string
+//   - [][]int: The indices of the fields that are selected
+//   - error: ErrUnsupportedUnion if the branches of a UNION select different fields
+func parse[T any](sql string, opts options) (string, [][]int, error) {
+	branches, separators := splitUnion(sql)
+	if len(branches) == 1 {
+		return parseSelect[T](sql, opts)
+	}
+	var parsedSQL strings.Builder
+	var indices [][]int
+	for i, branch := range branches {
+		parsedBranch, branchIndices, err := parseSelect[T](branch, opts)
+		if err != nil {
+			return sql, nil, err
+		}
+		if i > 0 && fmt.Sprint(branchIndices) != fmt.Sprint(indices) {
+			log.Error("union branches select different fields", "sql", sql)
+			return sql, nil, fmt.Errorf("%w: branch %d selects different fields than the first branch", ErrUnsupportedUnion, i+1)
+		}
+		indices = branchIndices
+		parsedSQL.WriteString(parsedBranch)
+		parsedSQL.WriteString(separators[i])
+	}
+	return parsedSQL.String(), indices, nil
+}
+
+// parse parses the generated SQL of the query, the result is shared through the global parse cache when the query
+// was created with WithParseCache
+//
+// Parameters:
+//   - sql: The generated SQL string
+//
+// Returns:
+//   - string: The parsed SQL string
+//   - [][]int: The indices of the fields that are selected
+//   - error: If the SQL can't be parsed
+func (query *QueryTemplate[T]) parse(sql string) (string, [][]int, error) {
+	if !query.options.parseCache {
+		return parse[T](sql, query.options)
+	}
+	key := newParseCacheKey[T](sql, query.options)
+	if parsedSQL, indices, ok := globalParseCache.get(key); ok {
+		return parsedSQL, indices, nil
+	}
+	parsedSQL, indices, err := parse[T](sql, query.options)
+	if err != nil {
+		return parsedSQL, indices, err
+	}
+	globalParseCache.put(key, parsedSQL, indices)
+	return parsedSQL, indices, nil
+}
+
+// parseSelect parses a single SELECT statement, see Parse for more details
+func parseSelect[T any](sql string, opts options) (string, [][]int, error) {
+	var tmp T
+	tableOrTables := reflect.ValueOf(tmp).Type()
+	selectedFields := []string{}
+	matches := selectRegex.FindAllStringSubmatch(sql, -1)
+	if returning := returningRegex.FindStringSubmatch(sql); returning != nil {
+		// the rows of a statement with a RETURNING clause are the returned columns, not the ones of a nested SELECT
+		matches = [][]string{returning}
+	}
+	allIndices := [][]int{}
+	// parse the sql template to see if we are selecting all fields
+	if len(matches) > 0 {
+		projection := matches[0][1]
+		// keep a DISTINCT modifier out of the columns so it is not mistaken for a column
+		distinct := ""
+		for _, match := range matches {
+			modifier := distinctRegex.FindString(match[1])
+			match[1] = match[1][len(modifier):]
+			if distinct == "" {
+				distinct = modifier
+			}
+		}
+		selectAll := strings.TrimSpace(matches[0][1]) == "*"
+		splitFields := splitColumns(matches[0][1])
+		if opts.positionalScan && !selectAll && !slices.ContainsFunc(splitFields, isStarColumn) {
+			// the columns are scanned into the fields in declaration order and the projection is kept as written
+			indices, err := positionalIndices(tableOrTables, opts.tagNames)
+			if err != nil {
+				return sql, nil, err
+			}
+			if len(indices) != len(splitFields) {
+				log.Error("column count does not match the scanned fields", "expected", len(indices), "columns", len(splitFields))
+				return sql, nil, fmt.Errorf("%w: expected %d columns, got %d", ErrColumnMismatch, len(indices), len(splitFields))
+			}
+			return sql, indices, nil
+		}
+		aliases := tableAliases(sql)
+		hasRest := false
+		multiTable := isMultiTable(tableOrTables, opts.tagNames)
+		// iterate over the fields of the struct to get the indices of the fields that we are selecting
+		for tableOrField := range iterStructFields(tableOrTables) {
+			if parseTQLTag(tableOrField, opts.tagNames).rest {
+				// the rest field receives the columns that are not mapped to other fields
+				hasRest = true
+				continue
+			}
+			tableName := ""
+			// qualifier is the name the table is referenced by in the sql, which is the alias if the table has one
+			qualifier := ""
+			tableOrFieldType := tableOrField.Type
+			indices := []int{}
+			tableOrFieldTag := parseTQLTag(tableOrField, opts.tagNames)
+			// column is true for a column next to the tables of a multi table struct, e.g. an aggregate or a timestamp
+			column := false
+			if !isTable(tableOrField, opts.tagNames) {
+				if multiTable {
+					column = true
+					tableOrFieldTag.omit = ""
+				} else {
+					// this means that this is a single table query
+					tableOrFieldType = tableOrTables
+				}
+			} else {
+				tableName = tableOrFieldTag.field
+				qualifier = tableName
+				if alias, ok := aliases[tableName]; ok {
+					qualifier = alias
+				}
+				indices = append(indices, tableOrField.Index[0])
+			}
+			// to select all fields from the table means we have a "*" or a "X.*" and that the fields are narrowed by a subquery
+			selectAllFromTable := !column && (selectAll || containsWords(matches[0][1], qualifier+".*")) && !matchesContainQualified(matches, qualifier)
+			fields := []reflect.StructField{tableOrField}
+			if !column {
+				var err error
+				if fields, err = columnFields(tableOrFieldType, opts.tagNames); err != nil {
+					return sql, nil, err
+				}
+			}
+			for _, field := range fields {
+				fieldTag := parseTQLTag(field, opts.tagNames)
+				if fieldTag.rest {
+					hasRest = true
+					continue
+				}
+				var qualifiedName string
+				if qualifier != "" {
+					qualifiedName = qualifier + "." + fieldTag.field
+				} else {
+					qualifiedName = fieldTag.field
+				}
+				// check if the field is omitted via the tql tag or the table tql tag
+				if fieldTag.omit == "true" || omits(tableOrFieldTag.omit, fieldTag.field, tableName+"."+fieldTag.field, qualifier+"."+fieldTag.field) {
+					continue
+				}
+				if fieldTag.expr {
+					// a computed column is never a column of the table, it is only selected by its alias
+					column, ok := aliasedColumn(fieldTag.field, splitFields)
+					if !ok {
+						log.Debug("computed column not found in the sql statement", "column", fieldTag.field, "sql", sql)
+						continue
+					}
+					selectedFields = append(selectedFields, column)
+					allIndices = append(allIndices, append(indices[:], field.Index...))
+					continue
+				}
+				// the columns of a table selected with a star are not looked up in the projection
+				if !selectAllFromTable && !matchesContainsWords(matches, qualifier+"."+fieldTag.field, fieldTag.field) {
+					log.Debug("column not found in the sql statement", "column", qualifiedName, "sql", sql)
+					continue
+				}
+				if selectAll {
+					// a lone star has no aliased expressions to keep
+					selectedFields = append(selectedFields, qualifiedName)
+				} else {
+					selectedFields = append(selectedFields, toSelectedField(qualifiedName, splitFields))
+				}
+				allIndices = append(allIndices, append(indices[:], field.Index...))
+			}
+
+			if tableOrFieldType == tableOrTables {
+				// make sure we break out of this loop if this is a single table query
+				break
+			}
+		}
+		// replace the selected fields with the qualified names unless the projection should be kept as written,
+		// which is always the case with a rest field since it receives the columns that are not mapped, an unmatched
+		// projection is kept so the statement still prepares and the scan reports ErrNoColumns
+		if !opts.noRewrite && !hasRest && len(selectedFields) > 0 {
+			sql = strings.Replace(sql, projection, distinct+strings.Join(selectedFields, ", "), 1)
+		}
+	}
+	return sql, allIndices, nil
+}
+
+// Generate generates the SQL template with the given data and returns the generated SQL string and any error that occurred.
+//
+// Parameters:
+//   - query: The QueryTemplate to generate. Must not be nil.
+//   - args: The arguments that will be passed to sql.Exec or sql.Query
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - string: The generated SQL string
+//   - error: If the template execution fails
+func (query *QueryTemplate[T]) Generate(data ...any) (string, []any, error) {
+	sql, params, err := query.generateDialect(query.options.dialect, data...)
+	if err != nil {
+		return sql, params, err
+	}
+	if query.options.whitespace == WhitespaceCollapse {
+		sql = collapseWhitespace(sql, query.options.dialect)
+	}
+	return sql, params, nil
+}
+
+// generateDialect generates the SQL template with the placeholders of the dialect, which is the dialect of the outer
+// query when the template is embedded with the tql function
+//
+// Parameters:
+//   - dialect: The dialect of the placeholders
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - string: The generated SQL string
+//   - []any: The params in placeholder order
+//   - error: If the template execution fails
+func (query *QueryTemplate[T]) generateDialect(dialect Dialect, data ...any) (string, []any, error) {
+	sqlTemplate, err := query.template.Clone()
+	if err != nil {
+		return "", nil, err
+	}
+	opts := query.options
+	opts.dialect = dialect
+	sql, params, _, err := generate[T](sqlTemplate, opts, data...)
+	return sql, params, err
+}
+
+// MustGenerate generates the SQL template with the given data and returns the generated SQL string.
+// It panics if an error occurs.
+//
+// Parameters:
+//   - query: The QueryTemplate to generate. Must not be nil.
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - string: The generated SQL string
+//   - error: If the template execution fails
+func (query *QueryTemplate[T]) MustGenerate(data ...any) (string, []any) {
+	sql, params, err := query.Generate(data...)
+	if err != nil {
+		panic(err)
+	}
+	return sql, params
+}
+
+// Analyze generates and parses the query without a database and returns what would be selected, which is the
+// introspection hook for tooling such as linters checking the templates against a schema.
+// The columns are the matched column names in scan order, qualified with the table name for multi-table structs,
+// alongside the index paths of the fields they are scanned into.
+//
+// Example usage:
+//
+//	sql, columns, indices, err := query.Analyze(Params{"Id": 1})
+//
+// Parameters:
+//   - data: Optional variadic parameters to pass to the template execution
+//
+// Returns:
+//   - string: The transformed SQL string with placeholders
+//   - []string: The matched column names
+//   - [][]int: The indices of the fields that are selected
+//   - error: If the template execution or the parsing fails
+func (query *QueryTemplate[T]) Analyze(data ...any) (sql string, columns []string, indices [][]int, err error) {
+	sql, _, indices, err = generateAndParse(query, data...)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return sql, indexColumns(reflect.TypeFor[T](), indices, query.options.tagNames), indices, nil
+}
+
+// Validate checks that every tagged struct field has a matching column in the projection of the SQL template and
+// that every projected column maps to a field. This catches typos such as SELECT User.nmae that would otherwise silently
+// scan nothing. Validation only runs when the projection is static, it is skipped with a warning when the projection
+// contains template actions since the columns are only known once the template is executed.
+// With WithPositionalScan only the number of columns of an explicit column list is checked.
+//
+// Example usage:
+//
+//	func TestQueries(t *testing.T) {
+//	    if err := userQuery.Validate(); err != nil {
+//	        t.Fatal(err)
+//	    }
+//	}
+//
+// Parameters:
+//   - query: The QueryTemplate to validate. Must not be nil.
+//
+// Returns:
+//   - error: ErrUnmatchedColumns listing the unmatched fields and columns
+func (query *QueryTemplate[T]) Validate() error {
+	if query == nil {
+		log.Error("Validate called on a nil query")
+		return ErrNilQuery
+	}
+	match := selectRegex.FindStringSubmatch(query.source)
+	if match == nil {
+		// only SELECT statements have a projection to validate
+		return nil
+	}
+	projection := match[1][len(distinctRegex.FindString(match[1])):]
+	leftDelim := query.options.leftDelim
+	if leftDelim == "" {
+		leftDelim = "{{"
+	}
+	if strings.Contains(projection, leftDelim) {
+		log.Warn("skipping validation of a dynamic projection", "projection", projection)
+		return nil
+	}
+	_, indices, err := parse[T](query.source, query.options)
+	if err != nil {
+		return err
+	}
+	if query.options.positionalScan && !slices.ContainsFunc(splitColumns(projection), isStarColumn) {
+		// the columns are mapped by position, parse already checked their count
+		return nil
+	}
+	selected := map[string]bool{}
+	for _, index := range indices {
+		selected[fmt.Sprint(index)] = true
+	}
+	aliases := tableAliases(query.source)
+	knownColumns := map[string]bool{}
+	unmatchedFields := []string{}
+	var tmp T
+	tableOrTables := reflect.TypeOf(tmp)
+	hasRest := false
+	multiTable := isMultiTable(tableOrTables, query.options.tagNames)
+	for tableOrField := range iterStructFields(tableOrTables) {
+		qualifier := ""
+		tableOrFieldType := tableOrField.Type
+		indices := []int{}
+		tableOrFieldTag := parseTQLTag(tableOrField, query.options.tagNames)
+		if tableOrFieldTag.rest {
+			hasRest = true
+			continue
+		}
+		column := false
+		if !isTable(tableOrField, query.options.tagNames) {
+			if multiTable {
+				column = true
+				tableOrFieldTag.omit = ""
+			} else {
+				tableOrFieldType = tableOrTables
+			}
+		} else {
+			qualifier = tableOrFieldTag.field
+			if alias, ok := aliases[qualifier]; ok {
+				qualifier = alias
+			}
+			indices = append(indices, tableOrField.Index[0])
+		}
+		fields := []reflect.StructField{tableOrField}
+		if !column {
+			var err error
+			if fields, err = columnFields(tableOrFieldType, query.options.tagNames); err != nil {
+				return err
+			}
+		}
+		for _, field := range fields {
+			fieldTag := parseTQLTag(field, query.options.tagNames)
+			if fieldTag.rest {
+				hasRest = true
+				continue
+			}
+			if fieldTag.omit == "true" || omits(tableOrFieldTag.omit, fieldTag.field, tableOrFieldTag.field+"."+fieldTag.field, qualifier+"."+fieldTag.field) {
+				continue
+			}
+			qualifiedName := fieldTag.field
+			if qualifier != "" {
+				qualifiedName = qualifier + "." + fieldTag.field
+			}
+			knownColumns[qualifiedName] = true
+			knownColumns[fieldTag.field] = true
+			if !selected[fmt.Sprint(append(indices[:], field.Index...))] {
+				unmatchedFields = append(unmatchedFields, qualifiedName)
+			}
+		}
+		if tableOrFieldType == tableOrTables {
+			break
+		}
+	}
+	unmatchedColumns := []string{}
+	for _, column := range splitColumns(projection) {
+		name := columnName(column)
+		// the columns that are not mapped to a field are scanned into the rest field
+		if hasRest || name == "*" || strings.HasSuffix(name, ".*") || knownColumns[name] {
+			continue
+		}
+		unmatchedColumns = append(unmatchedColumns, strings.TrimSpace(column))
+	}
+	if len(unmatchedFields) == 0 && len(unmatchedColumns) == 0 {
+		return nil
+	}
+	log.Error("struct fields and projected columns don't match", "fields", unmatchedFields, "columns", unmatchedColumns)
+	return fmt.Errorf("%w: unmatched fields [%s], unmatched columns [%s]", ErrUnmatchedColumns, strings.Join(unmatchedFields, ", "), strings.Join(unmatchedColumns, ", "))
+}
+
+// Close closes the prepared statement and any error that occurred.
+//
+// Parameters:
+//   - query: The QueryStmt to close. Must not be nil.
+//
+// Returns:
+//   - error: If closing the prepared statement fails
+func (query *QueryStmt[T]) Close() error {
+	if query == nil {
+		log.Error("Close called on a nil query")
+		return ErrNilQuery
+	}
+	query.mu.Lock()
+	defer query.mu.Unlock()
+	if query.prepared != nil {
+		query.prepared.Close()
+		query.prepared = nil
+	}
+	return nil
+}
+
+// statement returns the current prepared statement, which changes when a stale statement is re-prepared
+func (query *QueryStmt[T]) statement() *sql.Stmt {
+	query.mu.RLock()
+	defer query.mu.RUnlock()
+	return query.prepared
+}
+
+// Stmt returns the prepared statement, or nil after Close, as an escape hatch to database/sql features tql doesn't
+// expose, e.g. the column types of the rows. The raw statement bypasses the scanning of tql, its rows are scanned by
+// the caller in the order of the transformed SQL, and it is executed with Args to bind the params of the template.
+// With WithStmtRecovery a stale statement is replaced, so the statement shouldn't be kept past its use.
+//
+// Example usage:
+//
+//	rows, err := stmt.Stmt().QueryContext(ctx, stmt.Args(1)...)
+//
+// Returns:
+//   - *sql.Stmt: The prepared statement
+func (query *QueryStmt[T]) Stmt() *sql.Stmt {
+	if query == nil {
+		return nil
+	}
+	return query.statement()
+}
+
+// Args returns the arguments to execute the raw prepared statement with, which are the params bound by the template
+// followed by the data, see Stmt
+//
+// Parameters:
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - []any: The arguments of the prepared statement
+func (query *QueryStmt[T]) Args(data ...any) []any {
+	return append(query.sqlParams, data...)
+}
+
+// recoverStmt re-prepares the statement if stale statement recovery is enabled and the error is a stale statement error.
+//
+// Parameters:
+//   - ctx: The context for the statement preparation
+//   - stale: The statement that failed
+//   - err: The error returned by the statement
+//
+// Returns:
+//   - *sql.Stmt: The re-prepared statement
+//   - bool: True if the statement was re-prepared and the execution should be retried
+func (query *QueryStmt[T]) recoverStmt(ctx context.Context, stale *sql.Stmt, err error) (*sql.Stmt, bool) {
+	if query.template == nil || !query.template.options.stmtRecovery || query.preparer == nil || !isStaleStmtError(err) {
+		return nil, false
+	}
+	query.mu.Lock()
+	defer query.mu.Unlock()
+	if query.prepared == nil {
+		// the statement was closed by the caller, there is nothing to recover
+		return nil, false
+	}
+	if query.prepared != stale {
+		// another execution already re-prepared the statement
+		return query.prepared, true
+	}
+	log.WarnContext(ctx, "re-preparing stale statement", "error", err, "sql", query.SQL)
+	stmt, prepareErr := query.preparer.PrepareContext(ctx, query.SQL)
+	if prepareErr != nil {
+		log.ErrorContext(ctx, "failed to re-prepare stale statement", "error", prepareErr)
+		return nil, false
+	}
+	stale.Close()
+	query.prepared = stmt
+	return stmt, true
+}
+
+// invalidatedError wraps the error of a statement closed by database/sql with ErrStmtInvalidated, which happens to a
+// statement prepared on a transaction once it is committed or rolled back
+//
+// Parameters:
+//   - ctx: The context of the execution
+//   - err: The error returned by the statement
+//
+// Returns:
+//   - error: The wrapped error, or the error as is if the statement is not invalidated
+func (query *QueryStmt[T]) invalidatedError(ctx context.Context, err error) error {
+	if !errors.Is(err, sql.ErrTxDone) && !strings.Contains(err.Error(), "sql: statement is closed") {
+		return err
+	}
+	log.ErrorContext(ctx, "the statement is invalidated", "error", err, "sql", query.SQL)
+	return errors.Join(ErrStmtInvalidated, err)
+}
+
+// isStaleStmtError checks if the error is caused by a prepared statement that is no longer valid on the server,
+// e.g. after a server restart or a DDL change
+//
+// Parameters:
+//   - err: The error to check
+//
+// Returns:
+//   - bool: True if the statement should be re-prepared, false otherwise
+func isStaleStmtError(err error) bool {
+	return err != nil && staleStmtRegex.MatchString(err.Error())
+}
+
+// ExecContext executes a prepared statement with the given context and optional template data.
+// It returns the result of the query execution and any error that occurred.
+//
+// Parameters:
+//   - query: The QueryStmt to execute. Must not be nil.
+//   - ctx: The context for the query execution. Used for cancellation and timeouts.
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - sql.Result: The result of the query execution
+//   - error: If query execution fails
+func (query *QueryStmt[T]) ExecContext(ctx context.Context, data ...any) (result sql.Result, err error) {
+	if query == nil {
+		log.ErrorContext(ctx, "ExecContext called on a nil query")
+		return nil, ErrNilQuery
+	}
+	stmt := query.statement()
+	if stmt == nil {
+		log.ErrorContext(ctx, "ExecContext called on a nil prepared query")
+		return nil, ErrNilStmt
+	}
+	ctx, span := startSpan(ctx, query.queryOptions(), "tql.exec")
+	defer func() { span.End(err) }()
+	if threshold := query.queryOptions().slowQueryThreshold; threshold > 0 {
+		defer logSlowQuery(ctx, threshold, time.Now(), query.SQL, len(query.sqlParams)+len(data))
+	}
+	span.SetSQL(query.SQL)
+	return query.exec(ctx, stmt, data)
+}
+
+// ExecBatchContext executes the prepared statement once per param set, reusing the prepared statement instead of
+// preparing it for every execution like the package level Exec does. A statement prepared on a *sql.Tx runs every
+// execution in that transaction. The batch stops on the first error unless the template was created with
+// WithCollectBatchErrors, in which case every param set is executed and the errors are joined.
+//
+// Example usage:
+//
+//	results, err := stmt.ExecBatchContext(ctx, [][]any{{1, "John"}, {2, "Jane"}})
+//
+// Parameters:
+//   - ctx: The context for the executions. Used for cancellation and timeouts.
+//   - paramSets: The arguments of each execution
+//
+// Returns:
+//   - []sql.Result: The result of each execution in param set order, nil for a failed execution. When the batch stops
+//     on an error only the results of the executions before it are returned.
+//   - error: If an execution fails, naming the index of its param set
+func (query *QueryStmt[T]) ExecBatchContext(ctx context.Context, paramSets [][]any) (results []sql.Result, err error) {
+	if query == nil {
+		log.ErrorContext(ctx, "ExecBatchContext called on a nil query")
+		return nil, ErrNilQuery
+	}
+	stmt := query.statement()
+	if stmt == nil {
+		log.ErrorContext(ctx, "ExecBatchContext called on a nil prepared query")
+		return nil, ErrNilStmt
+	}
+	ctx, span := startSpan(ctx, query.queryOptions(), "tql.exec")
+	defer func() { span.End(err) }()
+	if threshold := query.queryOptions().slowQueryThreshold; threshold > 0 {
+		defer logSlowQuery(ctx, threshold, time.Now(), query.SQL, len(paramSets))
+	}
+	span.SetSQL(query.SQL)
+	collect := query.queryOptions().collectBatchErrors
+	results = make([]sql.Result, 0, len(paramSets))
+	var errs []error
+	for i, params := range paramSets {
+		// a stale statement re-prepared by an execution is used by the next ones
+		result, err := query.exec(ctx, query.statement(), params)
+		if err != nil {
+			log.ErrorContext(ctx, "batch execution failed", "paramSet", i, "error", err)
+			err = fmt.Errorf("param set %d: %w", i, err)
+			if !collect {
+				return results, err
+			}
+			errs = append(errs, err)
+		}
+		results = append(results, result)
+	}
+	return results, errors.Join(errs...)
+}
+
+// ExecBatch executes the prepared statement once per param set, see ExecBatchContext for more details
+//
+// Parameters:
+//   - paramSets: The arguments of each execution
+//
+// Returns:
+//   - []sql.Result: The result of each execution in param set order
+//   - error: If an execution fails
+func (query *QueryStmt[T]) ExecBatch(paramSets [][]any) ([]sql.Result, error) {
+	return query.ExecBatchContext(context.Background(), paramSets)
+}
+
+// exec executes the statement with the args, re-preparing it once if it is stale
+//
+// Parameters:
+//   - ctx: The context of the tql.exec span
+//   - stmt: The prepared statement, nil if the statement was closed
+//   - data: The arguments appended to the params of the template
+//
+// Returns:
+//   - sql.Result: The result of the execution
+//   - error: If the arguments are invalid or the execution fails
+func (query *QueryStmt[T]) exec(ctx context.Context, stmt *sql.Stmt, data []any) (sql.Result, error) {
+	if stmt == nil {
+		return nil, ErrNilStmt
+	}
+	args := append(query.sqlParams, data...)
+	if err := query.checkArgs(ctx, args); err != nil {
+		return nil, err
+	}
+	driverCtx, driverSpan := startSpan(ctx, query.queryOptions(), "tql.driver.exec")
+	result, err := stmt.ExecContext(driverCtx, args...)
+	if stmt, ok := query.recoverStmt(driverCtx, stmt, err); ok {
+		result, err = stmt.ExecContext(driverCtx, args...)
+	}
+	driverSpan.End(err)
+	if err != nil {
+		return result, query.paramError(ctx, query.invalidatedError(ctx, err), args)
+	}
+	return result, nil
+}
+
+// paramError names the param and its Go type when the driver failed to convert a bound argument,
+// e.g. "sql: converting argument $3 type" becomes "param .Settings (map[string]int)"
+//
+// Parameters:
+//   - ctx: The context of the execution
+//   - err: The error returned by the driver
+//   - args: The arguments the statement was executed with
+//
+// Returns:
+//   - error: The error wrapped with ErrUnsupportedParam if the argument is a param, the error as is otherwise
+func (query *QueryStmt[T]) paramError(ctx context.Context, err error, args []any) error {
+	match := argumentErrorRegex.FindStringSubmatch(err.Error())
+	if match == nil {
+		return err
+	}
+	ordinal, _ := strconv.Atoi(match[1])
+	if ordinal < 1 || ordinal > len(query.paramPaths) || ordinal > len(args) || query.paramPaths[ordinal-1] == "" {
+		return err
+	}
+	path := query.paramPaths[ordinal-1]
+	log.ErrorContext(ctx, "param is not a supported bind type", "param", path, "type", fmt.Sprintf("%T", args[ordinal-1]))
+	return errors.Join(fmt.Errorf("%w: param %s (%T) is not a supported bind type", ErrUnsupportedParam, path, args[ordinal-1]), err)
+}
+
+// checkArgs checks that every argument is a type the driver accepts before the statement is executed,
+// so the error names the argument instead of surfacing from database/sql
+//
+// Parameters:
+//   - ctx: The context of the execution
+//   - args: The arguments the statement is executed with
+//
+// Returns:
+//   - error: ErrInvalidArgument naming the first argument that can't be bound, also wrapped with ErrUnsupportedParam
+//     if the argument is a param
+func (query *QueryStmt[T]) checkArgs(ctx context.Context, args []any) error {
+	// named arguments are matched by name, so only positional arguments can be counted
+	if query.placeholders >= 0 && len(args) != query.placeholders && !slices.ContainsFunc(args, isNamedArg) {
+		expected := query.placeholders - len(query.sqlParams)
+		log.ErrorContext(ctx, "argument count does not match the placeholders", "expected", expected, "got", len(args)-len(query.sqlParams))
+		return fmt.Errorf("%w: the sql has %d placeholders and %d params, expected %d arguments, got %d", ErrArgCountMismatch,
+			query.placeholders, len(query.sqlParams), expected, len(args)-len(query.sqlParams))
+	}
+	for i, arg := range args {
+		if isBindable(arg) {
+			continue
+		}
+		log.ErrorContext(ctx, "argument is not a supported bind type", "argument", i+1, "type", fmt.Sprintf("%T", arg))
+		err := fmt.Errorf("%w: argument %d (%T) is not a supported bind type", ErrInvalidArgument, i+1, arg)
+		if i < len(query.paramPaths) && query.paramPaths[i] != "" {
+			return errors.Join(err, fmt.Errorf("%w: param %s (%T) is not a supported bind type", ErrUnsupportedParam, query.paramPaths[i], arg))
+		}
+		return err
+	}
+	return nil
+}
+
+// isNamedArg checks if the argument is a sql.NamedArg
+func isNamedArg(arg any) bool {
+	_, ok := arg.(sql.NamedArg)
+	return ok
+}
+
+// isBindable checks if database/sql can convert the value to a driver value, which is the case for nil, the bool,
+// integer, float and string kinds, []byte, time.Time, driver.Valuer, sql.NamedArg of those and pointers to those
+//
+// Parameters:
+//   - value: The value to check
+//
+// Returns:
+//   - bool: True if the value can be bound, false otherwise
+func isBindable(value any) bool {
+	switch value := value.(type) {
+	case nil, driver.Valuer, time.Time, []byte:
+		return true
+	case sql.NamedArg:
+		return isBindable(value.Value)
+	}
+	reflectedValue := reflect.ValueOf(value)
+	switch reflectedValue.Kind() {
+	case reflect.Pointer:
+		return reflectedValue.IsNil() || isBindable(reflectedValue.Elem().Interface())
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return reflectedValue.Type().Elem().Kind() == reflect.Uint8
+	}
+	return false
+}
+
+// Exec executes a prepared statement with the given database connection and optional template data.
+// It returns the result of the query execution and any error that occurred.
+//
+// Parameters:
+//   - query: The QueryStmt to execute. Must not be nil.
+//   - db: Database connection, can be either *sql.DB or *sql.Tx
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - sql.Result: The result of the query execution
+//   - error: If query execution fails
+func (query *QueryStmt[T]) Exec(data ...any) (sql.Result, error) {
+	if query == nil {
+		log.Error("Exec called on a nil query")
+		return nil, ErrNilQuery
+	}
+	return query.ExecContext(context.Background(), data...)
+}
+
+// QueryContext executes a prepared statement with the given context and optional template data.
+// It returns a slice of results of type T and any error that occurred.
+//
+// Parameters:
+//   - query: The QueryStmt to execute. Must not be nil.
+//   - ctx: The context for the query execution. Used for cancellation and timeouts.
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - []T: A slice of results of type T
+//   - error: If query execution fails
+func (query *QueryStmt[T]) QueryContext(ctx context.Context, data ...any) (results []T, err error) {
+	if query == nil {
+		log.ErrorContext(ctx, "QueryContext called on a nil query")
+		return nil, ErrNilQuery
+	}
+	return query.scan(ctx, -1, data...)
+}
+
+// QueryPtrContext executes a prepared statement with the given context and optional template data and returns a
+// pointer to every row, e.g. to keep wide structs out of the backing array of the slice. Every row is its own
+// allocation, so the rows don't alias each other.
+//
+// Example usage:
+//
+//	users, err := usersStmt.QueryPtrContext(ctx, accountId)
+//
+// Parameters:
+//   - query: The QueryStmt to execute. Must not be nil.
+//   - ctx: The context for the query execution. Used for cancellation and timeouts.
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - []*T: A slice of pointers to the results of type T
+//   - error: If query execution fails
+func (query *QueryStmt[T]) QueryPtrContext(ctx context.Context, data ...any) (results []*T, err error) {
+	if query == nil {
+		log.ErrorContext(ctx, "QueryPtrContext called on a nil query")
+		return nil, ErrNilQuery
+	}
+	err = query.scanEach(ctx, -1, func(row T) {
+		result := new(T)
+		*result = row
+		results = append(results, result)
+	}, data...)
+	return results, err
+}
+
+// QueryPtr executes a prepared statement with the given optional template data and returns a pointer to every row.
+// See QueryPtrContext for more details.
+//
+// Parameters:
+//   - query: The QueryStmt to execute. Must not be nil.
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - []*T: A slice of pointers to the results of type T
+//   - error: If query execution fails
+func (query *QueryStmt[T]) QueryPtr(data ...any) ([]*T, error) {
+	return query.QueryPtrContext(context.Background(), data...)
+}
+
+// QueryRowContext executes a prepared statement with the given context and optional template data and returns the
+// first row without allocating a slice. Like sql.Row, the rows after the first one are ignored and not read,
+// use OneContext to treat multiple rows as an error.
+//
+// Example usage:
+//
+//	user, err := userByIdStmt.QueryRowContext(ctx, userId)
+//	if errors.Is(err, ErrNoRows) {
+//	    // not found
+//	}
+//
+// Parameters:
+//   - query: The QueryStmt to execute. Must not be nil.
+//   - ctx: The context for the query execution. Used for cancellation and timeouts.
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - T: The first row
+//   - error: ErrNoRows if no rows matched or if query execution fails
+func (query *QueryStmt[T]) QueryRowContext(ctx context.Context, data ...any) (T, error) {
+	var result T
+	if query == nil {
+		log.ErrorContext(ctx, "QueryRowContext called on a nil query")
+		return result, ErrNilQuery
+	}
+	found := false
+	err := query.scanEach(ctx, 1, func(row T) {
+		result = row
+		found = true
+	}, data...)
+	if err != nil {
+		return result, err
+	}
+	if !found {
+		return result, ErrNoRows
+	}
+	return result, nil
+}
+
+// QueryRow executes a prepared statement with the given optional template data and returns the first row.
+// See QueryRowContext for more details.
+//
+// Parameters:
+//   - query: The QueryStmt to execute. Must not be nil.
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - T: The first row
+//   - error: ErrNoRows if no rows matched or if query execution fails
+func (query *QueryStmt[T]) QueryRow(data ...any) (T, error) {
+	return query.QueryRowContext(context.Background(), data...)
+}
+
+// scan executes the prepared statement and scans at most limit rows, a negative limit scans all the rows.
+// The rows are closed once the limit is reached so the rest of the result set is not read.
+//
+// Parameters:
+//   - ctx: The context for the query execution. Used for cancellation and timeouts.
+//   - limit: The maximum number of rows to scan
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - []T: A slice of results of type T
+//   - error: If query execution fails
+func (query *QueryStmt[T]) scan(ctx context.Context, limit int, data ...any) (results []T, err error) {
+	err = query.scanEach(ctx, limit, func(row T) {
+		results = append(results, row)
+	}, data...)
+	return results, err
+}
+
+// scanEach executes the prepared statement and passes at most limit scanned rows to yield, see scan for more details
+//
+// Parameters:
+//   - ctx: The context for the query execution. Used for cancellation and timeouts.
+//   - limit: The maximum number of rows to scan
+//   - yield: The function receiving the rows
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - error: If query execution fails
+func (query *QueryStmt[T]) scanEach(ctx context.Context, limit int, yield func(T), data ...any) (err error) {
+	stmt := query.statement()
+	if stmt == nil {
+		log.ErrorContext(ctx, "QueryContext called on a nil prepared query")
+		return ErrNilStmt
+	}
+	if len(query.indices) == 0 && query.rest == nil {
+		// scanning no fields would silently return zero values for every row
+		log.ErrorContext(ctx, "no struct field matches the projection", "sql", query.SQL)
+		return errors.Join(ErrExecutingQuery, fmt.Errorf("%w: %s", ErrNoColumns, query.SQL))
+	}
+	opts := query.queryOptions()
+	var scanDest T
+	scanDestValue := reflect.ValueOf(&scanDest).Elem()
+	// the layout of the fields is computed by the plan, only the destinations of this scanDest are created here
+	fieldsBuffer := query.scanFields()
+	defer query.releaseScanFields(fieldsBuffer)
+	fields := *fieldsBuffer
+	// the JSON columns are scanned as raw bytes that are decoded into the fields after every row
+	jsonValues := map[int]*[]byte{}
+	for i, field := range query.layout {
+		if field.json {
+			jsonValues[i] = &[]byte{}
+			fields[i] = jsonValues[i]
+			continue
+		}
+		fields[i] = scanDestination(field.value(scanDestValue), opts.nullAsZero)
+	}
+	ctx, span := startSpan(ctx, opts, "tql.query")
+	defer func() { span.End(err) }()
+	if threshold := opts.slowQueryThreshold; threshold > 0 {
+		defer logSlowQuery(ctx, threshold, time.Now(), query.SQL, len(query.sqlParams)+len(data))
+	}
+	span.SetSQL(query.SQL)
+	args := append(query.sqlParams, data...)
+	if err := query.checkArgs(ctx, args); err != nil {
+		return errors.Join(ErrExecutingQuery, err)
+	}
+	driverCtx, driverSpan := startSpan(ctx, opts, "tql.driver.query")
+	rows, err := stmt.QueryContext(driverCtx, args...)
+	if stmt, ok := query.recoverStmt(driverCtx, stmt, err); ok {
+		rows, err = stmt.QueryContext(driverCtx, args...)
+	}
+	driverSpan.End(err)
+	if err != nil {
+		return errors.Join(ErrExecutingQuery, query.paramError(ctx, query.invalidatedError(ctx, err), args))
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return errors.Join(ErrExecutingQuery, err)
+	}
+	restValues := map[string]*any{}
+	if query.rest != nil {
+		fields, restValues = query.restFields(fields, columns)
+	} else if len(columns) != len(fields) {
+		log.ErrorContext(ctx, "column count does not match the scanned fields", "expected", len(fields), "columns", columns)
+		return errors.Join(ErrExecutingQuery, fmt.Errorf("%w: expected %d columns, got %d [%s]", ErrColumnMismatch, len(fields), len(columns), strings.Join(columns, ", ")))
+	}
+	for limit != 0 && rows.Next() {
+		err := rows.Scan(fields...)
+		if err != nil {
+			return errors.Join(ErrExecutingQuery, nullScanError(err, func(column int) string {
+				// the columns only map to the indices in order without a rest field
+				if query.rest != nil || column >= len(query.indices) {
+					return ""
+				}
+				return fieldPath(reflect.TypeFor[T](), query.indices[column])
+			}))
+		}
+		// the columns are decoded in order so the first invalid one is reported
+		for i := range query.indices {
+			raw, ok := jsonValues[i]
+			if !ok {
+				continue
+			}
+			if err := decodeJSON(query.layout[i].value(scanDestValue), *raw); err != nil {
+				column := fieldPath(reflect.TypeFor[T](), query.indices[i])
+				log.ErrorContext(ctx, "failed to decode json column", "column", column, "error", err)
+				return errors.Join(ErrExecutingQuery, fmt.Errorf("%w: column %s: %w", ErrDecodingJSON, column, err))
+			}
+		}
+		if query.rest != nil {
+			// every row gets its own map so the results don't share it
+			rest := make(map[string]any, len(restValues))
+			for column, value := range restValues {
+				rest[column] = *value
+			}
+			scanDestValue.FieldByIndex(query.rest).Set(reflect.ValueOf(rest))
+		}
+		yield(scanDest)
+		limit--
+	}
+	// a driver error after the first rows, e.g. a dropped connection, ends the iteration like the last row
+	if err := rows.Err(); err != nil {
+		log.ErrorContext(ctx, "failed to iterate the rows", "error", err)
+		return errors.Join(ErrExecutingQuery, err)
+	}
+	return nil
+}
+
+// scanFields returns a slice of scan destinations with one entry per field, reused from a finished query if possible
 //
 // Returns:
-//   - string: The parsed SQL string
-//   - [][]int: The indices of the fields that are selected
-func Parse[T any](sql string) (string, [][]int) {
-	var tmp T
-	tableOrTables := reflect.ValueOf(tmp).Type()
-	selectedFields := []string{}
-	matches := selectRegex.FindAllStringSubmatch(sql, -1)
-	allIndices := [][]int{}
-	// parse the sql template to see if we are selecting all fields
-	if len(matches) > 0 {
-		selectAll := strings.TrimSpace(matches[0][1]) == "*"
-		splitFields := strings.Split(matches[0][1], ",")
-		// iterate over the fields of the struct to get the indices of the fields that we are selecting
-		for tableOrField := range iterStructFields(tableOrTables) {
-			tableName := ""
-			tableOrFieldType := tableOrField.Type
-			indices := []int{}
-			tableOrFieldTag := parseTQLTag(tableOrField)
-			if tableOrFieldType.Kind() != reflect.Struct {
-				// this means that this is a single table query
-				tableOrFieldType = tableOrTables
+//   - *[]any: The slice to pass back to releaseScanFields
+func (query *QueryStmt[T]) scanFields() *[]any {
+	if fields, ok := query.fieldsPool.Get().(*[]any); ok {
+		return fields
+	}
+	fields := make([]any, len(query.layout))
+	return &fields
+}
+
+// releaseScanFields clears the scan destinations so the pool doesn't keep the scanned struct alive and puts the slice
+// back in the pool
+//
+// Parameters:
+//   - fields: The slice returned by scanFields
+func (query *QueryStmt[T]) releaseScanFields(fields *[]any) {
+	clear(*fields)
+	query.fieldsPool.Put(fields)
+}
+
+// logSlowQuery logs a warning with the SQL and the number of bound arguments when the query took longer than the
+// threshold, the values of the arguments are not logged since they can hold personal data
+//
+// Parameters:
+//   - ctx: The context of the query
+//   - threshold: The duration above which the query is slow
+//   - start: The time the query started
+//   - sql: The SQL with placeholders
+//   - args: The number of bound arguments
+func logSlowQuery(ctx context.Context, threshold time.Duration, start time.Time, sql string, args int) {
+	if elapsed := time.Since(start); elapsed > threshold {
+		log.WarnContext(ctx, "slow query", "sql", sql, "args", args, "elapsed", elapsed, "threshold", threshold)
+	}
+}
+
+// queryOptions returns the options of the query template the statement was prepared from
+//
+// Returns:
+//   - options: The options of the query template, the defaults if there is no template
+func (query *QueryStmt[T]) queryOptions() options {
+	if query.template == nil {
+		return newOptions()
+	}
+	return query.template.options
+}
+
+// decodeJSON decodes the raw JSON of a column into the field, a NULL column leaves the field zero.
+// The field is reset first since the scan destination is reused for every row.
+//
+// Parameters:
+//   - field: The addressable field
+//   - raw: The raw JSON, nil for NULL
+//
+// Returns:
+//   - error: If the JSON can't be decoded into the field
+func decodeJSON(field reflect.Value, raw []byte) error {
+	field.SetZero()
+	if raw == nil {
+		return nil
+	}
+	return json.Unmarshal(raw, field.Addr().Interface())
+}
+
+// restFields maps the columns to the scanned fields by column name when the struct has a rest field.
+// The n-th column with a name is scanned into the n-th field with that name, the columns that don't match
+// a field are scanned into the values of the rest map.
+//
+// Parameters:
+//   - fields: The scan destinations of the fields in the order of the indices
+//   - columns: The columns returned by the query
+//
+// Returns:
+//   - []any: The scan destinations in the order of the columns
+//   - map[string]*any: The scan destinations of the rest columns keyed by column name
+func (query *QueryStmt[T]) restFields(fields []any, columns []string) ([]any, map[string]*any) {
+	tagNames := defaultTagNames
+	if query.template != nil {
+		tagNames = query.template.options.tagNames
+	}
+	byName := map[string][]any{}
+	for i, index := range query.indices {
+		name := parseTQLTag(reflect.TypeFor[T]().FieldByIndex(index), tagNames).field
+		byName[name] = append(byName[name], fields[i])
+	}
+	destinations := make([]any, len(columns))
+	restValues := map[string]*any{}
+	for i, column := range columns {
+		if matched := byName[column]; len(matched) > 0 {
+			destinations[i] = matched[0]
+			byName[column] = matched[1:]
+			continue
+		}
+		value := new(any)
+		destinations[i] = value
+		restValues[column] = value
+	}
+	return destinations, restValues
+}
+
+// Query executes a prepared statement with the given database connection and optional template data.
+// It returns a slice of results of type T and any error that occurred.
+//
+// Parameters:
+//   - query: The QueryStmt to execute. Must not be nil.
+//   - db: Database connection, can be either *sql.DB or *sql.Tx
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - []T: A slice of results of type T
+//   - error: If query execution fails
+func (query *QueryStmt[T]) Query(data ...any) (results []T, err error) {
+	if query == nil {
+		log.Error("Query called on a nil query")
+		return nil, ErrNilQuery
+	}
+	return query.QueryContext(context.Background(), data...)
+}
+
+// RowsContext executes a prepared statement and returns the raw rows for the advanced uses the scan of a single
+// result set doesn't cover, such as the multiple result sets of a stored procedure. The caller must close the rows,
+// ScanRows scans the current result set and rows.NextResultSet advances to the next one.
+// The spans of WithTracer and WithSlowQueryThreshold cover the execution, not the reading of the rows.
+//
+// Example usage:
+//
+//	stmt, err := Prepare(Must[User]("CALL usersAndAccounts()"), db)
+//	rows, err := stmt.RowsContext(ctx)
+//	defer rows.Close()
+//	users, err := ScanRows[User](rows)
+//	if rows.NextResultSet() {
+//	    accounts, err := ScanRows[Account](rows)
+//	}
+//
+// Parameters:
+//   - ctx: The context for the query execution
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - *sql.Rows: The rows of the query
+//   - error: If query execution fails
+func (query *QueryStmt[T]) RowsContext(ctx context.Context, data ...any) (_ *sql.Rows, err error) {
+	if query == nil {
+		log.ErrorContext(ctx, "RowsContext called on a nil query")
+		return nil, ErrNilQuery
+	}
+	stmt := query.statement()
+	if stmt == nil {
+		log.ErrorContext(ctx, "RowsContext called on a nil prepared query")
+		return nil, ErrNilStmt
+	}
+	opts := query.queryOptions()
+	ctx, span := startSpan(ctx, opts, "tql.query")
+	defer func() { span.End(err) }()
+	if threshold := opts.slowQueryThreshold; threshold > 0 {
+		defer logSlowQuery(ctx, threshold, time.Now(), query.SQL, len(query.sqlParams)+len(data))
+	}
+	span.SetSQL(query.SQL)
+	args := append(query.sqlParams, data...)
+	if err := query.checkArgs(ctx, args); err != nil {
+		return nil, errors.Join(ErrExecutingQuery, err)
+	}
+	driverCtx, driverSpan := startSpan(ctx, opts, "tql.driver.query")
+	rows, err := stmt.QueryContext(driverCtx, args...)
+	if stmt, ok := query.recoverStmt(driverCtx, stmt, err); ok {
+		rows, err = stmt.QueryContext(driverCtx, args...)
+	}
+	driverSpan.End(err)
+	if err != nil {
+		return nil, errors.Join(ErrExecutingQuery, query.paramError(ctx, query.invalidatedError(ctx, err), args))
+	}
+	return rows, nil
+}
+
+// Rows executes a prepared statement and returns the raw rows, see RowsContext for more details
+//
+// Parameters:
+//   - data: Optional variadic parameters to pass to the query execution
+//
+// Returns:
+//   - *sql.Rows: The rows of the query
+//   - error: If query execution fails
+func (query *QueryStmt[T]) Rows(data ...any) (*sql.Rows, error) {
+	return query.RowsContext(context.Background(), data...)
+}
+
+// ScanRows scans the current result set of the rows into T without parsing a projection, the columns are matched to
+// the fields of the single table struct T by column name and the columns without a field are discarded.
+// The rows are not closed and not advanced to the next result set, see QueryStmt.RowsContext.
+//
+// Parameters:
+//   - rows: The rows positioned on the result set to scan
+//   - maybeOptions: Optional options such as WithTagNames
+//
+// Returns:
+//   - []T: The scanned rows of the result set
+//   - error: If T is not a struct or scanning fails
+func ScanRows[T any](rows *sql.Rows, maybeOptions ...Option) ([]T, error) {
+	results := []T{}
+	if rows == nil {
+		log.Error("ScanRows called with nil rows")
+		return results, errors.Join(ErrExecutingQuery, ErrNilStmt)
+	}
+	reflectedType := reflect.TypeFor[T]()
+	if reflectedType.Kind() != reflect.Struct {
+		log.Error("a struct is required", "received", reflectedType)
+		return results, ErrInvalidType
+	}
+	opts := newOptions(maybeOptions...)
+	fields, err := columnFields(reflectedType, opts.tagNames)
+	if err != nil {
+		return results, err
+	}
+	fieldsByColumn := map[string]reflect.StructField{}
+	for _, field := range fields {
+		fieldTag := parseTQLTag(field, opts.tagNames)
+		if fieldTag.omit == "true" || fieldTag.rest || !field.IsExported() {
+			continue
+		}
+		fieldsByColumn[fieldTag.field] = field
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return results, errors.Join(ErrExecutingQuery, err)
+	}
+	for rows.Next() {
+		var result T
+		resultValue := reflect.ValueOf(&result).Elem()
+		destinations := make([]any, len(columns))
+		jsonValues := map[int]*[]byte{}
+		for i, column := range columns {
+			field, ok := fieldsByColumn[column]
+			switch {
+			case !ok:
+				destinations[i] = new(any)
+			case parseTQLTag(field, opts.tagNames).json:
+				jsonValues[i] = &[]byte{}
+				destinations[i] = jsonValues[i]
+			default:
+				destinations[i] = scanDestination(resultValue.FieldByIndex(field.Index), opts.nullAsZero)
+			}
+		}
+		if err := rows.Scan(destinations...); err != nil {
+			log.Error("failed to scan row", "error", err)
+			return results, errors.Join(ErrExecutingQuery, nullScanError(err, func(column int) string {
+				return fieldsByColumn[columns[column]].Name
+			}))
+		}
+		for i := range columns {
+			raw, ok := jsonValues[i]
+			if !ok {
+				continue
+			}
+			if err := decodeJSON(resultValue.FieldByIndex(fieldsByColumn[columns[i]].Index), *raw); err != nil {
+				log.Error("failed to decode json column", "column", columns[i], "error", err)
+				return results, errors.Join(ErrExecutingQuery, fmt.Errorf("%w: column %s: %w", ErrDecodingJSON, columns[i], err))
+			}
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return results, errors.Join(ErrExecutingQuery, err)
+	}
+	return results, nil
+}
+
+// parseTQLTag parses the tql struct tag options.
+// The tql tag is a list of ; separated options, either key=value pairs such as omit=createdAt or the column name
+// followed by , separated flags such as settings,json.
+// The column name is read from the first of the tag names that is set, the options are only read from the tql tag.
+//
+// Parameters:
+//   - field: The struct field to parse
+//   - tagNames: The struct tags to read the column name from in order of precedence
+//
+// Returns:
+//   - struct {
+//     omit  string
+//     field string
+//     rest  bool
+//     pk    bool
+//     json  bool
+//     expr  bool
+//     }: The parsed struct tag options
+func parseTQLTag(field reflect.StructField, tagNames []string) (results struct {
+	omit  string
+	field string
+	rest  bool
+	pk    bool
+	json  bool
+	expr  bool
+}) {
+	results.field = field.Name
+	tqlField := ""
+	for _, option := range strings.Split(field.Tag.Get("tql"), ";") {
+		if key, value, ok := strings.Cut(option, "="); ok {
+			switch strings.TrimSpace(key) {
+			case "omit":
+				results.omit = strings.TrimSpace(value)
+			}
+			continue
+		}
+		name, flags, _ := strings.Cut(option, ",")
+		if name = strings.TrimSpace(name); name != "" {
+			tqlField = name
+		}
+		for _, flag := range strings.Split(flags, ",") {
+			switch strings.TrimSpace(flag) {
+			case "rest":
+				results.rest = true
+			case "pk":
+				results.pk = true
+			case "json":
+				results.json = true
+			case "expr":
+				results.expr = true
+			}
+		}
+	}
+	for _, tagName := range tagNames {
+		name := tqlField
+		if tagName != "tql" {
+			// other libraries use comma separated options after the column name e.g. db:"created_at,omitempty"
+			name, _, _ = strings.Cut(field.Tag.Get(tagName), ",")
+			name = strings.TrimSpace(name)
+		}
+		if name != "" && name != "-" {
+			results.field = name
+			break
+		}
+	}
+	return results
+}
+
+// restField finds the top level field tagged with the rest flag that receives the columns not mapped to other fields
+//
+// Parameters:
+//   - reflectedType: The reflected type of the struct
+//   - tagNames: The struct tags to read the column names from
+//
+// Returns:
+//   - []int: The index of the rest field or nil if there is none
+//   - error: If the rest field is not a map[string]any
+func restField(reflectedType reflect.Type, tagNames []string) ([]int, error) {
+	if reflectedType.Kind() != reflect.Struct {
+		return nil, nil
+	}
+	for field := range iterStructFields(reflectedType) {
+		if !parseTQLTag(field, tagNames).rest {
+			continue
+		}
+		if field.Type != reflect.TypeFor[map[string]any]() {
+			log.Error("the rest field must be a map[string]any", "field", field.Name, "type", field.Type)
+			return nil, errors.Join(ErrInvalidType, errors.New("rest field "+field.Name+" must be a map[string]any"))
+		}
+		return field.Index, nil
+	}
+	return nil, nil
+}
+
+// tableAliases finds the tables that are given an alias in the FROM and JOIN clauses
+//
+// Parameters:
+//   - sql: The SQL string to search
+//
+// Returns:
+//   - map[string]string: The aliases keyed by table name
+func tableAliases(sql string) map[string]string {
+	aliases := map[string]string{}
+	// the alias is matched separately so a keyword following the table, e.g. FROM User JOIN, is not consumed
+	for _, match := range tableRegex.FindAllStringSubmatchIndex(sql, -1) {
+		alias := aliasRegex.FindStringSubmatch(sql[match[1]:])
+		if alias == nil || aliasKeywords[strings.ToUpper(alias[1])] {
+			continue
+		}
+		aliases[sql[match[2]:match[3]]] = alias[1]
+	}
+	return aliases
+}
+
+// splitUnion splits the SQL string into the branches of a top level UNION, a UNION nested in parentheses or a
+// quoted string is not split
+//
+// Parameters:
+//   - sql: The SQL string to split
+//
+// Returns:
+//   - []string: The branches, a single branch if there is no UNION
+//   - []string: The UNION operator following each branch, empty for the last branch
+func splitUnion(sql string) ([]string, []string) {
+	branches := []string{}
+	separators := []string{}
+	depth := 0
+	start := 0
+	var quote rune
+	for i, char := range sql {
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '\'' || char == '"' || char == '`':
+			quote = char
+		case char == '(':
+			depth++
+		case char == ')':
+			depth--
+		case depth == 0 && i >= start && (char == 'U' || char == 'u') && (i == 0 || !isWordChar(sql[i-1])):
+			if separator := unionRegex.FindString(sql[i:]); separator != "" {
+				branches = append(branches, sql[start:i])
+				separators = append(separators, separator)
+				start = i + len(separator)
+			}
+		}
+	}
+	branches = append(branches, sql[start:])
+	return branches, append(separators, "")
+}
+
+// isWordChar checks if the byte is a letter, digit or underscore
+func isWordChar(char byte) bool {
+	return char == '_' || (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9')
+}
+
+// countStatements counts the statements separated by semicolons, ignoring the semicolons in quoted strings, quoted
+// identifiers and comments. A trailing semicolon doesn't start another statement.
+//
+// Parameters:
+//   - sql: The generated SQL
+//   - dialect: The dialect, a backslash only escapes a quote and # only starts a comment in MySQL
+//
+// Returns:
+//   - int: The number of statements
+func countStatements(sql string, dialect Dialect) int {
+	count := 0
+	// pending is true when a statement started after the last semicolon
+	pending := false
+	var quote byte
+	for i := 0; i < len(sql); i++ {
+		char := sql[i]
+		switch {
+		case quote != 0:
+			if char == '\\' && dialect == DialectMySQL {
+				i++
+			} else if char == quote {
+				quote = 0
+			}
+		case char == '\'' || char == '"' || char == '`':
+			quote = char
+			pending = true
+		case strings.HasPrefix(sql[i:], "--") || (char == '#' && dialect == DialectMySQL):
+			if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+				i += end
 			} else {
-				tableName = tableOrFieldTag.field
-				indices = append(indices, tableOrField.Index[0])
+				i = len(sql)
 			}
-			// to select all fields from the table means we have a "*" or a "X.*" and that the fields are narrowed by a subquery
-			selectAllFromTable := (selectAll || containsWords(matches[0][1], tableName+`\.\*`)) && !matchesContainsWords(matches, tableName+`\.\b`)
-			for field := range iterStructFields(tableOrFieldType) {
-				fieldTag := parseTQLTag(field)
-				var qualifiedName string
-				if tableName != "" {
-					qualifiedName = tableName + "." + fieldTag.field
-				} else {
-					qualifiedName = fieldTag.field
-				}
-				// check if the field is omitted via the tql tag or the table tql tag
-				if fieldTag.omit == "true" || containsWords(tableOrFieldTag.omit, fieldTag.field, tableName+`\.`+fieldTag.field) {
-					continue
-				}
-				if !matchesContainsWords(matches, tableName+`\.`+fieldTag.field, fieldTag.field) && !selectAllFromTable {
-					log.Debug("column not found in the sql statement", "column", qualifiedName, "sql", sql)
-					continue
-				}
-				selectedFields = append(selectedFields, toSelectedField(qualifiedName, splitFields))
-				allIndices = append(allIndices, append(indices[:], field.Index...))
+		case strings.HasPrefix(sql[i:], "/*"):
+			if end := strings.Index(sql[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(sql)
+			}
+		case char == ';':
+			if pending {
+				count++
+				pending = false
 			}
+		case char != ' ' && char != '\t' && char != '\n' && char != '\r':
+			pending = true
+		}
+	}
+	if pending {
+		count++
+	}
+	return count
+}
 
-			if tableOrFieldType == tableOrTables {
-				// make sure we break out of this loop if this is a single table query
-				break
+// countPlaceholders counts the positional placeholders of the SQL, ignoring the ones in quoted strings, quoted
+// identifiers and comments. A repeated $n ordinal of postgres is counted once.
+//
+// Parameters:
+//   - sql: The transformed SQL
+//   - dialect: The dialect of the placeholders
+//
+// Returns:
+//   - int: The number of arguments the SQL expects, -1 if it can't be told such as with the ?NNN placeholders of SQLite
+func countPlaceholders(sql string, dialect Dialect) int {
+	count := 0
+	var quote byte
+	for i := 0; i < len(sql); i++ {
+		char := sql[i]
+		switch {
+		case quote != 0:
+			if char == '\\' && dialect == DialectMySQL {
+				i++
+			} else if char == quote {
+				quote = 0
+			}
+		case char == '\'' || char == '"' || char == '`':
+			quote = char
+		case strings.HasPrefix(sql[i:], "--") || (char == '#' && dialect == DialectMySQL):
+			if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(sql)
+			}
+		case strings.HasPrefix(sql[i:], "/*"):
+			if end := strings.Index(sql[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(sql)
+			}
+		case char == '?' && dialect == DialectMySQL:
+			if i+1 < len(sql) && sql[i+1] >= '0' && sql[i+1] <= '9' {
+				return -1
 			}
+			count++
+		case char == '$' && dialect == DialectPostgres:
+			end := i + 1
+			for end < len(sql) && sql[end] >= '0' && sql[end] <= '9' {
+				end++
+			}
+			if ordinal, err := strconv.Atoi(sql[i+1 : end]); err == nil {
+				count = max(count, ordinal)
+			}
+			i = end - 1
 		}
-		// replace the selected fields with the qualified names
-		sql = strings.Replace(sql, matches[0][1], strings.Join(selectedFields, ", "), 1)
 	}
-	return sql, allIndices
+	return count
 }
 
-// Generate generates the SQL template with the given data and returns the generated SQL string and any error that occurred.
+// collapseWhitespace collapses every run of whitespace to a single space and trims the SQL, the whitespace in quoted
+// strings, quoted identifiers and block comments is kept and a line comment keeps the line break that ends it
 //
 // Parameters:
-//   - query: The QueryTemplate to generate. Must not be nil.
-//   - args: The arguments that will be passed to sql.Exec or sql.Query
-//   - data: Optional variadic parameters to pass to the query execution
+//   - sql: The generated SQL
+//   - dialect: The dialect, a backslash only escapes a quote and # only starts a comment in MySQL
 //
 // Returns:
-//   - string: The generated SQL string
-//   - error: If the template execution fails
-func (query *QueryTemplate[T]) Generate(data ...any) (string, []any, error) {
-	sqlTemplate, err := query.template.Clone()
-	if err != nil {
-		return "", nil, err
+//   - string: The collapsed SQL
+func collapseWhitespace(sql string, dialect Dialect) string {
+	var collapsed strings.Builder
+	collapsed.Grow(len(sql))
+	// space is true when whitespace was skipped since the last written byte
+	space := false
+	var quote byte
+	for i := 0; i < len(sql); i++ {
+		char := sql[i]
+		if quote != 0 {
+			collapsed.WriteByte(char)
+			if char == '\\' && dialect == DialectMySQL && i+1 < len(sql) {
+				i++
+				collapsed.WriteByte(sql[i])
+			} else if char == quote {
+				quote = 0
+			}
+			continue
+		}
+		if char == ' ' || char == '\t' || char == '\n' || char == '\r' {
+			space = true
+			continue
+		}
+		// no space is needed at the start of the SQL or of the line after a line comment
+		if space && collapsed.Len() > 0 && !strings.HasSuffix(collapsed.String(), "\n") {
+			collapsed.WriteByte(' ')
+		}
+		space = false
+		switch {
+		case char == '\'' || char == '"' || char == '`':
+			quote = char
+			collapsed.WriteByte(char)
+		case strings.HasPrefix(sql[i:], "--") || (char == '#' && dialect == DialectMySQL):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				collapsed.WriteString(strings.TrimRight(sql[i:], " \t\r"))
+				i = len(sql)
+				continue
+			}
+			collapsed.WriteString(strings.TrimRight(sql[i:i+end], " \t\r") + "\n")
+			i += end
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				collapsed.WriteString(sql[i:])
+				i = len(sql)
+				continue
+			}
+			collapsed.WriteString(sql[i : i+end+4])
+			i += end + 3
+		default:
+			collapsed.WriteByte(char)
+		}
 	}
-	return Generate[T](sqlTemplate, data...)
+	return collapsed.String()
 }
 
-// MustGenerate generates the SQL template with the given data and returns the generated SQL string.
-// It panics if an error occurs.
+// splitColumns splits a projection into its columns on the commas that are not nested in parentheses
 //
 // Parameters:
-//   - query: The QueryTemplate to generate. Must not be nil.
-//   - data: Optional variadic parameters to pass to the query execution
+//   - projection: The projection of a SELECT statement
 //
 // Returns:
-//   - string: The generated SQL string
-//   - error: If the template execution fails
-func (query *QueryTemplate[T]) MustGenerate(data ...any) (string, []any) {
-	sqlTemplate, err := query.template.Clone()
-	if err != nil {
-		panic(err)
+//   - []string: The columns
+func splitColumns(projection string) []string {
+	columns := []string{}
+	depth := 0
+	start := 0
+	for i, char := range projection {
+		switch char {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				columns = append(columns, projection[start:i])
+				start = i + 1
+			}
+		}
 	}
-	return MustGenerate[T](sqlTemplate, data...)
+	return append(columns, projection[start:])
 }
 
-// Close closes the prepared statement and any error that occurred.
+// columnName returns the name of a projected column, which is its alias if it has one
 //
 // Parameters:
-//   - query: The QueryStmt to close. Must not be nil.
+//   - column: The projected column expression
 //
 // Returns:
-//   - error: If closing the prepared statement fails
-func (query *QueryStmt[T]) Close() error {
-	if query == nil {
-		log.Error("Close called on a nil query")
-		return ErrNilQuery
+//   - string: The name of the column
+func columnName(column string) string {
+	column = strings.TrimSpace(column)
+	if index := strings.LastIndex(strings.ToLower(column), " as "); index >= 0 {
+		// an AS nested in an expression such as CAST(User.id AS CHAR) is not an alias
+		if alias := strings.TrimSpace(column[index+4:]); !strings.ContainsAny(alias, "() ") {
+			return unquoteAlias(alias)
+		}
 	}
-	if query.prepared != nil {
-		query.prepared.Close()
-		query.prepared = nil
+	// the AS keyword is optional, e.g. COUNT(*) total or User.id userId
+	index := strings.LastIndexAny(column, " \t\r\n")
+	if index < 0 {
+		return column
 	}
-	return nil
+	alias := column[index+1:]
+	expression := strings.TrimSpace(column[:index])
+	if !columnAliasRegex.MatchString(alias) || notAliases[strings.ToUpper(alias)] {
+		return column
+	}
+	if strings.HasSuffix(expression, ")") || strings.HasSuffix(expression, "'") ||
+		(identRegex.MatchString(expression) && !notAliases[strings.ToUpper(expression)]) {
+		return unquoteAlias(alias)
+	}
+	return column
 }
 
-// ExecContext executes a prepared statement with the given context and optional template data.
-// It returns the result of the query execution and any error that occurred.
+// unquoteAlias removes the backticks or double quotes around an alias
 //
 // Parameters:
-//   - query: The QueryStmt to execute. Must not be nil.
-//   - ctx: The context for the query execution. Used for cancellation and timeouts.
-//   - data: Optional variadic parameters to pass to the query execution
+//   - alias: The alias as written
 //
 // Returns:
-//   - sql.Result: The result of the query execution
-//   - error: If query execution fails
-func (query *QueryStmt[T]) ExecContext(ctx context.Context, data ...any) (sql.Result, error) {
-	if query == nil {
-		log.ErrorContext(ctx, "ExecContext called on a nil query")
-		return nil, ErrNilQuery
-	}
-	if query.prepared == nil {
-		log.ErrorContext(ctx, "ExecContext called on a nil prepared query")
-		return nil, ErrNilStmt
+//   - string: The alias without quotes
+func unquoteAlias(alias string) string {
+	if len(alias) >= 2 && (alias[0] == '`' || alias[0] == '"') && alias[len(alias)-1] == alias[0] {
+		return alias[1 : len(alias)-1]
 	}
-	return query.prepared.ExecContext(ctx, append(query.sqlParams, data...)...)
+	return alias
 }
 
-// Exec executes a prepared statement with the given database connection and optional template data.
-// It returns the result of the query execution and any error that occurred.
+// toSelectedField converts the qualified name to the selected field, a column that is an aliased expression such as
+// COUNT(Account.id) AS count is kept as written so only its alias is matched against the field
 //
 // Parameters:
-//   - query: The QueryStmt to execute. Must not be nil.
-//   - db: Database connection, can be either *sql.DB or *sql.Tx
-//   - data: Optional variadic parameters to pass to the query execution
+//   - qualifiedName: The qualified name of the field
+//   - selectedFields: The selected fields
 //
 // Returns:
-//   - sql.Result: The result of the query execution
-//   - error: If query execution fails
-func (query *QueryStmt[T]) Exec(data ...any) (sql.Result, error) {
-	if query == nil {
-		log.Error("Exec called on a nil query")
-		return nil, ErrNilQuery
+//   - string: The selected field
+func toSelectedField(qualifiedName string, selectedFields []string) string {
+	for _, field := range selectedFields {
+		field = strings.TrimSpace(field)
+		if name := columnName(field); name != field && name == qualifiedName {
+			return field
+		}
 	}
-	return query.ExecContext(context.Background(), data...)
+	return qualifiedName
 }
 
-// QueryContext executes a prepared statement with the given context and optional template data.
-// It returns a slice of results of type T and any error that occurred.
+// isStarColumn checks if a projected column selects all the columns of the tables or of a table, e.g. * or User.*
+func isStarColumn(column string) bool {
+	name := columnName(column)
+	return name == "*" || strings.HasSuffix(name, ".*")
+}
+
+// positionalIndices returns the indices of the fields scanned by position, which are the column fields of the
+// tables and the columns next to them in declaration order, see WithPositionalScan
 //
 // Parameters:
-//   - query: The QueryStmt to execute. Must not be nil.
-//   - ctx: The context for the query execution. Used for cancellation and timeouts.
-//   - data: Optional variadic parameters to pass to the query execution
+//   - reflectedType: The reflected type of the result struct
+//   - tagNames: The struct tags to read the column names and flags from
 //
 // Returns:
-//   - []T: A slice of results of type T
-//   - error: If query execution fails
-func (query *QueryStmt[T]) QueryContext(ctx context.Context, data ...any) (results []T, err error) {
-	if query == nil {
-		log.ErrorContext(ctx, "QueryContext called on a nil query")
-		return nil, ErrNilQuery
-	}
-	var scanDest T
-	scanDestValue := reflect.ValueOf(&scanDest).Elem()
-	fields := []any{}
-	for _, fieldIndex := range query.indices {
-		field := scanDestValue.FieldByIndex(fieldIndex)
-		fields = append(fields, field.Addr().Interface())
-	}
-	rows, err := query.prepared.QueryContext(ctx, append(query.sqlParams, data...)...)
-	if err != nil {
-		return results, errors.Join(ErrExecutingQuery, err)
-	}
-	for rows.Next() {
-		err := rows.Scan(fields...)
+//   - [][]int: The indices of the fields in declaration order
+//   - error: If two fields of a table are ambiguous
+func positionalIndices(reflectedType reflect.Type, tagNames []string) ([][]int, error) {
+	indices := [][]int{}
+	multiTable := isMultiTable(reflectedType, tagNames)
+	for tableOrField := range iterStructFields(reflectedType) {
+		tableOrFieldTag := parseTQLTag(tableOrField, tagNames)
+		if tableOrFieldTag.rest || tableOrFieldTag.omit == "true" {
+			continue
+		}
+		if multiTable && !isTable(tableOrField, tagNames) {
+			indices = append(indices, tableOrField.Index)
+			continue
+		}
+		tableType := reflectedType
+		tableIndex := []int{}
+		if multiTable {
+			tableType = tableOrField.Type
+			tableIndex = tableOrField.Index
+		}
+		fields, err := columnFields(tableType, tagNames)
 		if err != nil {
-			return results, errors.Join(ErrExecutingQuery, err)
+			return nil, err
+		}
+		for _, field := range fields {
+			fieldTag := parseTQLTag(field, tagNames)
+			if fieldTag.rest || fieldTag.omit == "true" {
+				continue
+			}
+			indices = append(indices, append(tableIndex[:len(tableIndex):len(tableIndex)], field.Index...))
+		}
+		if !multiTable {
+			break
 		}
-		results = append(results, scanDest)
 	}
-	return results, nil
+	return indices, nil
 }
 
-// Query executes a prepared statement with the given database connection and optional template data.
-// It returns a slice of results of type T and any error that occurred.
+// aliasedColumn finds the projected column named after the alias of a computed column such as
+// CONCAT(firstName, ' ', lastName) AS fullName, aliases are matched case-insensitively like the databases do
 //
 // Parameters:
-//   - query: The QueryStmt to execute. Must not be nil.
-//   - db: Database connection, can be either *sql.DB or *sql.Tx
-//   - data: Optional variadic parameters to pass to the query execution
+//   - alias: The column name of the field tagged with the expr flag
+//   - selectedFields: The selected fields
 //
 // Returns:
-//   - []T: A slice of results of type T
-//   - error: If query execution fails
-func (query *QueryStmt[T]) Query(data ...any) (results []T, err error) {
-	if query == nil {
-		log.Error("Query called on a nil query")
-		return nil, ErrNilQuery
+//   - string: The selected field as written
+//   - bool: True if a projected column is named after the alias, false otherwise
+func aliasedColumn(alias string, selectedFields []string) (string, bool) {
+	for _, field := range selectedFields {
+		field = strings.TrimSpace(field)
+		if strings.EqualFold(columnName(field), alias) {
+			return field, true
+		}
 	}
-	return query.QueryContext(context.Background(), data...)
+	return "", false
 }
 
-// parseTQLTag parses the tql struct tag options
+// matchesContainsWords checks if the matches contain any of the words, see containsWords
 //
 // Parameters:
-//   - field: The struct field to parse
+//   - matches: The matches to check
+//   - words: The words to check for
 //
 // Returns:
-//   - struct {
-//     omit  string
-//     field string
-//     }: The parsed struct tag options
-func parseTQLTag(field reflect.StructField) (results struct {
-	omit  string
-	field string
-}) {
-	matches := tagRegex.FindAllStringSubmatch(field.Tag.Get("tql"), -1)
-	results.field = field.Name
+//   - bool: True if any of the words are found in the matches, false otherwise
+func matchesContainsWords(matches [][]string, words ...string) bool {
 	for _, match := range matches {
-		value := strings.TrimSpace(match[2])
-		if value != "" {
-			switch strings.TrimSpace(match[1]) {
-			case "omit":
-				results.omit = strings.TrimSpace(match[2])
-			}
-			continue
-		} else if value != "-" {
-			results.field = strings.TrimSpace(match[0])
+		if containsWords(match[1], words...) {
+			return true
 		}
 	}
-	return results
+	return false
 }
 
-// toSelectedField converts the qualified name to the selected field
+// matchesContainQualified checks if the matches contain a column qualified by the qualifier, e.g. User.id for User
 //
 // Parameters:
-//   - qualifiedName: The qualified name of the field
-//   - selectedFields: The selected fields
+//   - matches: The matches to check
+//   - qualifier: The table name or alias
 //
 // Returns:
-//   - string: The selected field
-func toSelectedField(qualifiedName string, selectedFields []string) string {
-	for _, field := range selectedFields {
-		maybeAlias := strings.Split(field, " as ")
-		if len(maybeAlias) > 1 {
-			if strings.TrimSpace(maybeAlias[1]) == qualifiedName {
-				return maybeAlias[0] + " as " + qualifiedName
-			}
+//   - bool: True if a qualified column is found in the matches, false otherwise
+func matchesContainQualified(matches [][]string, qualifier string) bool {
+	for _, match := range matches {
+		if containsWord(match[1], qualifier+".", true) {
+			return true
 		}
 	}
-	return qualifiedName
+	return false
 }
 
-// matchesContainsWords checks if the matches contain any of the words
+// omits checks if the omit option of a table tag lists any of the names, the option is a list of field names and
+// qualified Table.field names separated by commas, e.g. omit=createdAt,User.updatedAt
 //
 // Parameters:
-//   - matches: The matches to check
-//   - words: The words to check for
+//   - omit: The omit option of the table tag
+//   - names: The names of the field
 //
 // Returns:
-//   - bool: True if any of the words are found in the matches, false otherwise
-func matchesContainsWords(matches [][]string, words ...string) bool {
-	for _, match := range matches {
-		if containsWords(match[1], words...) {
+//   - bool: True if the field is omitted, false otherwise
+func omits(omit string, names ...string) bool {
+	for _, omitted := range strings.FieldsFunc(omit, func(char rune) bool { return char == ',' || unicode.IsSpace(char) }) {
+		if slices.Contains(names, omitted) {
 			return true
 		}
 	}
 	return false
 }
 
-// containsWords checks if the source string contains any of the words
+// containsWords checks if the source string contains any of the words. A word matches where it starts at a word
+// boundary that is not preceded by a dot, so id matches "id" and "COUNT(id)" but not "User.id", and User.id matches
+// "User.id" but not "x.User.id". The end of the word is not checked, so id also matches "idx".
 //
 // Parameters:
 //   - source: The source string to check
@@ -736,17 +3515,98 @@ func matchesContainsWords(matches [][]string, words ...string) bool {
 //   - bool: True if any of the words are found in the source string, false otherwise
 func containsWords(source string, words ...string) bool {
 	for _, word := range words {
-		regex, err := regexp.Compile(`(^|[^.])\b` + word)
-		if err != nil {
+		if containsWord(source, word, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsWord checks if the source string contains the word, see containsWords
+//
+// Parameters:
+//   - source: The source string to check
+//   - word: The word to check for
+//   - wordAfter: True if the word must be followed by a word character, e.g. the column after "User."
+//
+// Returns:
+//   - bool: True if the word is found in the source string, false otherwise
+func containsWord(source string, word string, wordAfter bool) bool {
+	for offset := 0; offset <= len(source); {
+		found := strings.Index(source[offset:], word)
+		if found < 0 {
 			return false
 		}
-		if regex.MatchString(source) {
+		start := offset + found
+		end := start + len(word)
+		if (start == 0 || source[start-1] != '.') &&
+			isWordAt(source, start-1) != isWordAt(source, start) &&
+			(!wordAfter || isWordAt(source, end)) {
 			return true
 		}
+		offset = start + 1
+	}
+	return false
+}
+
+// isWordAt checks if the byte at the index is a word character like the \b of regexp, [0-9A-Za-z_]
+//
+// Parameters:
+//   - source: The source string
+//   - index: The index of the byte, out of range is not a word character
+//
+// Returns:
+//   - bool: True if the byte is a word character, false otherwise
+func isWordAt(source string, index int) bool {
+	if index < 0 || index >= len(source) {
+		return false
+	}
+	char := source[index]
+	return char == '_' || char >= '0' && char <= '9' || char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z'
+}
+
+// isNil checks if the value is nil or a nil pointer, map, slice, func, chan or interface
+//
+// Parameters:
+//   - value: The value to check
+//
+// Returns:
+//   - bool: True if the value is nil, false otherwise
+func isNil(value any) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		return v.IsNil()
 	}
 	return false
 }
 
+// indexColumns returns the column names of the fields at the indices, qualified with the table name when the struct
+// holds one struct per table
+//
+// Parameters:
+//   - reflectedType: The reflected type of the struct
+//   - indices: The indices of the selected fields
+//   - tagNames: The struct tags to read the column names from
+//
+// Returns:
+//   - []string: The column names in the order of the indices
+func indexColumns(reflectedType reflect.Type, indices [][]int, tagNames []string) []string {
+	multiTable := isMultiTable(reflectedType, tagNames)
+	columns := make([]string, len(indices))
+	for i, index := range indices {
+		columns[i] = parseTQLTag(reflectedType.FieldByIndex(index), tagNames).field
+		// a column next to the tables is not qualified
+		if multiTable && isTable(reflectedType.Field(index[0]), tagNames) {
+			columns[i] = parseTQLTag(reflectedType.Field(index[0]), tagNames).field + "." + columns[i]
+		}
+	}
+	return columns
+}
+
 // iterStructFields returns an iterator over the fields of a struct type
 //
 // Parameters:
@@ -765,3 +3625,171 @@ func iterStructFields(reflectedType reflect.Type) iter.Seq[reflect.StructField]
 		},
 	)
 }
+
+// iterColumnFields returns an iterator over the column fields of a table struct. The fields of nested structs are
+// yielded in place of the struct with their index path from the table, so FieldByIndex reaches them at any depth.
+//
+// Parameters:
+//   - reflectedType: The reflected type of the table struct
+//   - tagNames: The struct tags to read the column names from
+//
+// Returns:
+//   - iter.Seq[reflect.StructField]: An iterator over the column fields of the struct
+func iterColumnFields(reflectedType reflect.Type, tagNames []string) iter.Seq[reflect.StructField] {
+	return iter.Seq[reflect.StructField](
+		func(yield func(reflect.StructField) bool) {
+			walkColumnFields(reflectedType, nil, tagNames, yield)
+		},
+	)
+}
+
+// columnFields returns the column fields of a table struct, see iterColumnFields. Like the promotion of embedded
+// fields in Go, a nested column shadowed by a shallower field with the same name is dropped.
+//
+// Parameters:
+//   - reflectedType: The reflected type of the table struct
+//   - tagNames: The struct tags to read the column names from
+//
+// Returns:
+//   - []reflect.StructField: The column fields
+//   - error: ErrAmbiguousField if two fields at the same depth have the same column name
+func columnFields(reflectedType reflect.Type, tagNames []string) ([]reflect.StructField, error) {
+	fields := []reflect.StructField{}
+	byColumn := map[string]int{}
+	for field := range iterColumnFields(reflectedType, tagNames) {
+		fieldTag := parseTQLTag(field, tagNames)
+		if fieldTag.rest {
+			fields = append(fields, field)
+			continue
+		}
+		prior, ok := byColumn[fieldTag.field]
+		// direct fields of the table can map to the same column on purpose, only promoted fields are ambiguous
+		if !ok || (len(field.Index) == 1 && len(fields[prior].Index) == 1) {
+			byColumn[fieldTag.field] = len(fields)
+			fields = append(fields, field)
+			continue
+		}
+		switch priorDepth := len(fields[prior].Index); {
+		case len(field.Index) < priorDepth:
+			fields[prior] = field
+		case len(field.Index) == priorDepth:
+			log.Error("ambiguous field", "column", fieldTag.field, "type", reflectedType)
+			return nil, fmt.Errorf("%w: %s and %s of %s both map to column %s, qualify them with a tql tag", ErrAmbiguousField,
+				fieldPath(reflectedType, fields[prior].Index), fieldPath(reflectedType, field.Index), reflectedType, fieldTag.field)
+		}
+	}
+	return fields, nil
+}
+
+// fieldPath returns the dotted names of the fields along the index path, e.g. Base.Id
+//
+// Parameters:
+//   - reflectedType: The reflected type of the struct
+//   - index: The index path of the field
+//
+// Returns:
+//   - string: The dotted field names
+func fieldPath(reflectedType reflect.Type, index []int) string {
+	names := make([]string, len(index))
+	for i, fieldIndex := range index {
+		field := reflectedType.Field(fieldIndex)
+		names[i] = field.Name
+		reflectedType = field.Type
+	}
+	return strings.Join(names, ".")
+}
+
+// walkColumnFields yields the column fields of a struct prefixed with the index path of the struct, see iterColumnFields
+//
+// Parameters:
+//   - reflectedType: The reflected type of the struct
+//   - path: The index path of the struct from the table
+//   - tagNames: The struct tags to read the column names from
+//   - yield: The function receiving the fields
+//
+// Returns:
+//   - bool: False if yield stopped the iteration, true otherwise
+func walkColumnFields(reflectedType reflect.Type, path []int, tagNames []string, yield func(reflect.StructField) bool) bool {
+	for field := range iterStructFields(reflectedType) {
+		field.Index = append(append([]int{}, path...), field.Index...)
+		if isNestedStruct(field.Type) {
+			fieldTag := parseTQLTag(field, tagNames)
+			if fieldTag.omit == "true" {
+				continue
+			}
+			// a struct decoded from a JSON column is a single column
+			if !fieldTag.rest && !fieldTag.json {
+				if !walkColumnFields(field.Type, field.Index, tagNames, yield) {
+					return false
+				}
+				continue
+			}
+		}
+		if !yield(field) {
+			return false
+		}
+	}
+	return true
+}
+
+// isNestedStruct checks if the type is a struct whose fields are columns, structs that scan or value themselves such
+// as time.Time or sql.NullString and structs with a registered scanner are columns
+//
+// Parameters:
+//   - reflectedType: The reflected type to check
+//
+// Returns:
+//   - bool: True if the fields of the struct are columns, false otherwise
+func isNestedStruct(reflectedType reflect.Type) bool {
+	return reflectedType.Kind() == reflect.Struct && !isScalarStruct(reflectedType)
+}
+
+// isScalarStruct checks if the type is a struct that is scanned as a single column, which is time.Time, the sql.Null
+// types and any other struct implementing sql.Scanner or driver.Valuer or with a registered scanner
+//
+// Parameters:
+//   - reflectedType: The reflected type to check
+//
+// Returns:
+//   - bool: True if the struct is a single column, false otherwise
+func isScalarStruct(reflectedType reflect.Type) bool {
+	return reflectedType.Kind() == reflect.Struct &&
+		(reflectedType == reflect.TypeFor[time.Time]() ||
+			reflect.PointerTo(reflectedType).Implements(reflect.TypeFor[sql.Scanner]()) ||
+			reflect.PointerTo(reflectedType).Implements(reflect.TypeFor[driver.Valuer]()) ||
+			hasScanner(reflectedType))
+}
+
+// isTable checks if a top level field of the result struct is a table whose fields are columns, as opposed to a column
+// of a single table struct such as a time.Time or a struct decoded from a JSON column
+//
+// Parameters:
+//   - field: The top level field of the result struct
+//   - tagNames: The struct tags to read the flags from
+//
+// Returns:
+//   - bool: True if the field is a table, false otherwise
+func isTable(field reflect.StructField, tagNames []string) bool {
+	return isNestedStruct(field.Type) && !parseTQLTag(field, tagNames).json
+}
+
+// isMultiTable checks if the result struct holds one struct per table, which is told by its first field that is not
+// a rest field. The other fields of a multi table struct that are not tables are columns next to the tables.
+//
+// Parameters:
+//   - reflectedType: The reflected type of the result struct
+//   - tagNames: The struct tags to read the flags from
+//
+// Returns:
+//   - bool: True if the struct holds one struct per table, false if it is a single table struct
+func isMultiTable(reflectedType reflect.Type, tagNames []string) bool {
+	if reflectedType.Kind() != reflect.Struct {
+		return false
+	}
+	for field := range iterStructFields(reflectedType) {
+		if !parseTQLTag(field, tagNames).rest {
+			return isTable(field, tagNames)
+		}
+	}
+	return false
+}
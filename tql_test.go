@@ -1,10 +1,20 @@
 package tql
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"math"
+	"math/big"
+	"math/rand/v2"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -293,6 +303,43 @@ func TestParamList(t *testing.T) {
 	}
 }
 
+func TestParamEmptyList(t *testing.T) {
+	db := mock(t)
+	for _, test := range []struct {
+		name      string
+		options   []Option
+		ids       []int
+		sql       string
+		notInRows int
+	}{
+		{"single", nil, []int{1}, "SELECT id FROM User WHERE User.id NOT IN (?)", 0},
+		{"empty", nil, []int{}, "SELECT id FROM User WHERE User.id NOT IN (NULL)", 0},
+		{"nil", nil, nil, "SELECT id FROM User WHERE User.id NOT IN (NULL)", 0},
+		{"no rows", []Option{WithEmptyList(EmptyListNoRows)}, nil, "SELECT id FROM User WHERE User.id NOT IN (SELECT NULL WHERE 1 = 0)", 1},
+	} {
+		query := MustWithOptions[User](`SELECT User.id FROM User WHERE User.id NOT IN {{ param .Ids }}`, test.options...)
+		stmt, err := Prepare(query, db, Params{"Ids": test.ids})
+		if err != nil {
+			t.Fatal(test.name, err)
+		}
+		if stmt.SQL != test.sql {
+			t.Fatal(test.name, "unexpected sql", stmt.SQL)
+		}
+		users, err := stmt.Query()
+		stmt.Close()
+		if err != nil {
+			t.Fatal(test.name, err)
+		}
+		if len(users) != test.notInRows {
+			t.Fatal(test.name, "expected", test.notInRows, "users, got", users)
+		}
+	}
+	query := MustWithOptions[User](`SELECT User.id FROM User WHERE User.id IN {{ param .Ids }}`, WithEmptyList(EmptyListError))
+	if _, err := Prepare(query, db, Params{"Ids": []int{}}); !errors.Is(err, ErrEmptyList) || !strings.Contains(err.Error(), ".Ids") {
+		t.Fatal("expected ErrEmptyList naming .Ids, got", err)
+	}
+}
+
 func TestParamMultiple(t *testing.T) {
 	db := mock(t)
 	query, err := New[User](`SELECT User.id, User.name, User.createdAt FROM User where User.id = {{ param .Id}} and User.name = {{ param .Name}}`)
@@ -434,6 +481,43 @@ func TestWithMissingFunction(t *testing.T) {
 	}
 }
 
+func TestUndefinedFunction(t *testing.T) {
+	_, err := New[User](`SELECT User.id FROM User WHERE User.uuid = '{{ uuid }}'`)
+	if !errors.Is(err, ErrParsingTemplate) {
+		t.Fatal("expected ErrParsingTemplate, got", err)
+	}
+	if !strings.Contains(err.Error(), "function uuid is not defined") {
+		t.Fatal("expected the error to name the function, got", err)
+	}
+}
+
+func TestContainsWords(t *testing.T) {
+	// the scan must match like the regexp it replaced over random sources
+	random := rand.New(rand.NewPCG(1, 2))
+	pick := func(alphabet []string, maxLength int) string {
+		var builder strings.Builder
+		for range random.IntN(maxLength + 1) {
+			builder.WriteString(alphabet[random.IntN(len(alphabet))])
+		}
+		return builder.String()
+	}
+	sourceAlphabet := []string{"a", "b", "id", "User", ".", "*", " ", "_", "1", ",", "(", ")", "\n", "é"}
+	wordAlphabet := []string{"a", "b", "id", "User", "_", "1", ".", "*"}
+	for range 20000 {
+		source := pick(sourceAlphabet, 12)
+		word := pick(wordAlphabet, 3)
+		expected := regexp.MustCompile(`(^|[^.])\b` + regexp.QuoteMeta(word)).MatchString(source)
+		if containsWords(source, word) != expected {
+			t.Fatalf("expected containsWords(%q, %q) to be %v", source, word, expected)
+		}
+		qualifier := pick(wordAlphabet[:5], 2)
+		expected = regexp.MustCompile(`(^|[^.])\b` + regexp.QuoteMeta(qualifier) + `\.\b`).MatchString(source)
+		if containsWord(source, qualifier+".", true) != expected {
+			t.Fatalf("expected a column qualified by %q in %q to be %v", qualifier, source, expected)
+		}
+	}
+}
+
 func TestWithNilDB(t *testing.T) {
 	type UserAccount struct {
 		User
@@ -474,262 +558,339 @@ func TestJoin(t *testing.T) {
 	}
 }
 
-func TestNestedSelect(t *testing.T) {
+func TestTableAlias(t *testing.T) {
 	db := mock(t)
-	type Results struct {
+	type UserAccount struct {
 		User    User
 		Account Account
 	}
-	type Query struct {
-		Account Account
-		User    User
-	}
-	query, err := New[Results](`SELECT User.*, Account.id FROM Account INNER JOIN (SELECT User.id,  User.createdAt FROM User where User.id = ?) AS User ON User.id = Account.userId`)
+	query, err := New[UserAccount](`SELECT u.id, u.name, a.id FROM User u JOIN Account AS a ON a.userId = u.id WHERE u.id = ?`)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	stmt, err := Prepare(query, db, Params{"User": Params{"Id": 1}, "Account": Account{Id: 2}})
+	sql, indices, err := Explain(query)
 	if err != nil {
 		t.Fatal(err)
 	}
-	results, err := stmt.Query(1)
-	if err != nil {
-		t.Fatal(err)
+	if sql != "SELECT u.id, u.name, a.id FROM User u JOIN Account AS a ON a.userId = u.id WHERE u.id = ?" || len(indices) != 3 {
+		t.Fatal("unexpected sql", sql, indices)
 	}
-	log.Info("results", "results", results)
-}
-
-func TestParamPreventsInjection(t *testing.T) {
-	db := mock(t)
-	var numUsersBefore int
-	if err := db.QueryRow("SELECT COUNT(*) FROM User").Scan(&numUsersBefore); err != nil {
+	results, err := Query(query, db, 1)
+	if err != nil {
 		t.Fatal(err)
 	}
-	if numUsersBefore == 0 {
-		t.Fatal("need users in the database to compare against, got no users")
-	}
-	type Results struct {
-		User User `tql:"omit=createdAt"`
+	if len(results) != 1 {
+		t.Fatal("expected 1 result, got", len(results))
 	}
-	query, err := New[User](`SELECT uuid, name FROM User WHERE User.name = {{ param .name }}`)
-	if err != nil {
-		t.Fatal(err)
+	if results[0].User.Id != 1 {
+		t.Fatal("expected id 1, got", results[0].User.Id)
 	}
-	name := "John Doe"
-	badInputs := []string{
-		name,
-		name + "'; DROP TABLE User; --",
+	if results[0].Account.Id != 2 {
+		t.Fatal("expected id 2, got", results[0].Account.Id)
 	}
-	for _, badInput := range badInputs {
-		queryStmt, err := Prepare(query, db, Params{"name": badInput})
-		if err != nil {
-			t.Fatal(err)
-		}
-		results, err := queryStmt.Query()
-		slog.Info("results", "results", results)
+}
+
+func TestTableAliasTag(t *testing.T) {
+	type UserAccount struct {
+		User    User    `tql:"u"`
+		Account Account `tql:"a"`
 	}
-	var numUsersAfter int
-	if err := db.QueryRow("SELECT COUNT(*) FROM User").Scan(&numUsersAfter); err != nil {
-		t.Fatal(err)
+	sql, indices := Parse[UserAccount](`SELECT u.*, a.id FROM User u JOIN Account a ON a.userId = u.id`)
+	if sql != "SELECT u.id, u.name, u.uuid, u.createdAt, a.id FROM User u JOIN Account a ON a.userId = u.id" {
+		t.Fatal("unexpected sql", sql)
 	}
-	if numUsersBefore != numUsersAfter {
-		t.Fatalf("expected %d users, got %d", numUsersBefore, numUsersAfter)
+	if fmt.Sprint(indices) != "[[0 0] [0 1] [0 2] [0 3] [1 0]]" {
+		t.Fatal("unexpected indices", indices)
 	}
 }
 
-func TestNestedSelectWithAlias(t *testing.T) {
+func TestTableTagQualifiesColumns(t *testing.T) {
 	db := mock(t)
-	type Results struct {
-		User struct {
-			UserId int `tql:"userId"`
-		}
-		Account Account
-	}
-	type Query struct {
-		Account Account
-		User    User
+	type UserAccount struct {
+		User    User    `tql:"u;omit=u.createdAt"`
+		Account Account `tql:"a"`
 	}
-	query, err := New[Results](`SELECT User.*, Account.id FROM Account INNER JOIN (SELECT User.id as userId,  User.createdAt FROM User where User.id = ?) AS User ON User.userId = Account.userId`)
+	query, err := New[UserAccount](`SELECT u.*, a.id FROM User u JOIN Account a ON a.userId = u.id WHERE u.id = ?`)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	stmt, err := Prepare(query, db, Params{"User": Params{"Id": 1}, "Account": Account{Id: 2}})
+	sql, _, err := Explain(query)
 	if err != nil {
 		t.Fatal(err)
 	}
-	results, err := stmt.Query(1)
+	if sql != "SELECT u.id, u.name, u.uuid, a.id FROM User u JOIN Account a ON a.userId = u.id WHERE u.id = ?" {
+		t.Fatal("unexpected sql", sql)
+	}
+	results, err := Query(query, db, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
-	log.Info("results", "results", results)
-}
-func TestWithTemplate(t *testing.T) {
-	db := mock(t)
+	if len(results) != 1 || results[0].User.Id != 1 || results[0].Account.Id != 2 {
+		t.Fatal("expected user 1 with account 2, got", results)
+	}
+	// a tag naming the table is matched by the alias of the table in the sql
 	type Results struct {
-		User User `tql:"omit=createdAt"`
+		User User `tql:"User;omit=u.createdAt"`
 	}
-	query, err := New[User](`SELECT uuid, name FROM User WHERE User.name = '{{ .name }}'`)
-	if err != nil {
-		t.Fatal(err)
+	sql, indices := Parse[Results](`SELECT u.* FROM User u`)
+	if sql != "SELECT u.id, u.name, u.uuid FROM User u" || len(indices) != 3 {
+		t.Fatal("unexpected sql", sql, indices)
 	}
+}
 
-	queryStmt, err := Prepare(query, db, Params{"name": "John Doe"})
+func TestPostgresRepeatedParam(t *testing.T) {
+	query, err := NewWithOptions[User](`SELECT User.id FROM User WHERE User.id = {{ param .Id }} OR User.name = {{ param .Name }} ORDER BY User.id = {{ param .Id }} DESC`, WithDialect(DialectPostgres))
 	if err != nil {
 		t.Fatal(err)
 	}
-	results, err := queryStmt.Query()
-	slog.Info("results", "results", results)
+	sql, args, err := GenerateAndArgs(query, Params{"Id": 1, "Name": "John Doe"})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(results) != 1 {
-		t.Fatal("expected 1 result, got", len(results))
+	if sql != "SELECT id FROM User WHERE User.id = $1 OR User.name = $2 ORDER BY User.id = $1 DESC" {
+		t.Fatal("expected the repeated param to reuse $1, got", sql)
 	}
-}
-
-func TestWithConditionalTable(t *testing.T) {
-	db := mock(t)
-	type Results struct {
-		User    User
-		Account Account
+	if fmt.Sprint(args) != "[1 John Doe]" {
+		t.Fatal("expected the repeated param to be bound once, got", args)
 	}
-	query, err := New[Results](`SELECT {{ .Table }}.id FROM {{ .Table }} WHERE {{ .Table }}.id = ?`)
+	// the same path holding another value in a range is bound again
+	query, err = NewWithOptions[User](`SELECT User.id FROM User WHERE User.id IN ({{ range $i, $id := .Ids }}{{ if $i }}, {{ end }}{{ param $id }}{{ end }})`, WithDialect(DialectPostgres))
 	if err != nil {
 		t.Fatal(err)
 	}
-	stmt, err := Prepare(query, db, Params{"Table": "User"})
+	sql, args, err = GenerateAndArgs(query, Params{"Ids": []int{1, 2}})
 	if err != nil {
 		t.Fatal(err)
 	}
-	results, err := stmt.Query(1)
-	if err != nil {
-		t.Fatal(err)
+	if sql != "SELECT id FROM User WHERE User.id IN ($1, $2)" || len(args) != 2 {
+		t.Fatal("expected each value to be bound, got", sql, args)
 	}
-	slog.Info("results", "results", results)
 }
 
-func TestWithNilQuery(t *testing.T) {
+func TestUnsupportedParamType(t *testing.T) {
 	db := mock(t)
-	var nilQuery *QueryTemplate[any]
-	if _, err := Prepare(nilQuery, db, Params{"createdAt": time.Now().Format("2006-01-02 15:04:05")}); !errors.Is(err, ErrPreparingQuery) {
+	query, err := New[User](`SELECT User.id FROM User WHERE User.id = {{ param .Id }} AND User.name = {{ param .Settings }}`)
+	if err != nil {
 		t.Fatal(err)
 	}
-	if _, err := Query(nilQuery, db); !errors.Is(err, ErrExecutingQuery) {
+	stmt, err := Prepare(query, db, Params{"Id": 1, "Settings": map[string]int{"a": 1}})
+	if err != nil {
 		t.Fatal(err)
 	}
+	defer stmt.Close()
+	_, err = stmt.Query()
+	if !errors.Is(err, ErrUnsupportedParam) {
+		t.Fatal("expected ErrUnsupportedParam, got", err)
+	}
+	if !strings.Contains(err.Error(), "param .Settings (map[string]int) is not a supported bind type") {
+		t.Fatal("expected the error to name the param, got", err)
+	}
 }
 
-func TestWithNilTemplate(t *testing.T) {
+func TestInvalidArgument(t *testing.T) {
 	db := mock(t)
-	queryWithNilTemplate := &QueryTemplate[any]{}
-	if _, err := Prepare(queryWithNilTemplate, db); !errors.Is(err, ErrNilTemplate) {
+	_, err := Exec(Must[User](`UPDATE User SET name = ? WHERE id = ?`), db, struct{ Name string }{"Jane Doe"}, 1)
+	if !errors.Is(err, ErrInvalidArgument) || errors.Is(err, ErrUnsupportedParam) {
+		t.Fatal("expected ErrInvalidArgument, got", err)
+	}
+	if !strings.Contains(err.Error(), "argument 1 (struct { Name string }) is not a supported bind type") {
+		t.Fatal("expected the error to name the argument, got", err)
+	}
+	_, err = Query(Must[User](`SELECT User.id FROM User WHERE User.id = ?`), db, make(chan int))
+	if !errors.Is(err, ErrInvalidArgument) {
+		t.Fatal("expected ErrInvalidArgument, got", err)
+	}
+	id := 1
+	name := sql.NullString{String: "Jane Doe", Valid: true}
+	var uuid *string
+	if _, err := Exec(Must[User](`UPDATE User SET name = ?, uuid = ? WHERE id = ?`), db, &name, uuid, &id); err != nil {
 		t.Fatal(err)
 	}
 }
 
-func TestWithFunctions(t *testing.T) {
+func TestWithParseCache(t *testing.T) {
 	db := mock(t)
+	ClearParseCache()
+	defer ClearParseCache()
 	type Results struct {
-		User User `tql:"user;omit=createdAt"`
+		User User
 	}
-	query, err := New[Results](`INSERT INTO User (name, id, uuid) VALUES (?, ?, '{{ uuid }}')`, Functions{"uuid": func() string { return "123" }})
-	if err != nil {
-		t.Fatal(err)
+	first := MustWithOptions[Results](`SELECT User.id, User.name FROM User WHERE User.id = ?`, WithParseCache())
+	second := MustWithOptions[Results](`SELECT User.id, User.name FROM User WHERE User.id = ?`, WithParseCache())
+	for _, query := range []*QueryTemplate[Results]{first, second} {
+		results, err := Query(query, db, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || results[0].User.Id != 1 {
+			t.Fatal("expected user 1, got", results)
+		}
 	}
-	if _, err := Prepare(query, db); err != nil {
-		t.Fatal(err)
+	if globalParseCache.len() != 1 {
+		t.Fatal("expected 1 cached parse result, got", globalParseCache.len())
 	}
-	if _, err := Exec(query, db, "Billy Joel", 2); err != nil {
-		t.Fatal(err)
+	// the least recently used result is evicted once the cache is full
+	cache := newParseCache(1)
+	cache.put(parseCacheKey{sql: "a"}, "a", nil)
+	cache.put(parseCacheKey{sql: "b"}, "b", nil)
+	if _, _, ok := cache.get(parseCacheKey{sql: "a"}); ok {
+		t.Fatal("expected a to be evicted")
+	}
+	if sql, _, ok := cache.get(parseCacheKey{sql: "b"}); !ok || sql != "b" {
+		t.Fatal("expected b to be cached, got", sql, ok)
 	}
 }
 
-func TestComplex(t *testing.T) {
+func TestDeleteByPK(t *testing.T) {
 	db := mock(t)
-	type Results struct {
-		User User `tql:"omit=createdAt"`
-	}
-	// templates are only rendered during the prepare to prevent SQL injections use
-	query, err := New[Results](`SELECT {{ .Select }} FROM User {{ if .Where}} WHERE {{ .Where }} {{end}}`)
+	ctx := context.Background()
+	result, err := DeleteByPK[Account](ctx, db, 2)
 	if err != nil {
 		t.Fatal(err)
 	}
-	queryStmt, err := Prepare(query, db, Params{"Select": "User.id, User.name", "Where": "User.id = 1"})
-	if err != nil {
+	if affected, _ := result.RowsAffected(); affected != 1 {
+		t.Fatal("expected 1 deleted account, got", affected)
+	}
+	type Membership struct {
+		UserId    int    `tql:"userId,pk"`
+		AccountId int    `tql:"accountId,pk"`
+		Role      string `tql:"role"`
+	}
+	if _, err := db.Exec(`CREATE TABLE Membership (userId INTEGER, accountId INTEGER, role TEXT, PRIMARY KEY (userId, accountId));
+		INSERT INTO Membership (userId, accountId, role) VALUES (1, 2, 'owner'), (1, 3, 'member')`); err != nil {
 		t.Fatal(err)
 	}
-	results, err := queryStmt.Query()
-	if err != nil {
+	if _, err := DeleteByPK[Membership](ctx, db, []any{1, 2}); err != nil {
 		t.Fatal(err)
 	}
-	if len(results) != 1 {
-		t.Fatal("expected 1 result, got", len(results))
+	if _, err := DeleteByPK[Membership](ctx, db, Membership{UserId: 1, AccountId: 3}); err != nil {
+		t.Fatal(err)
 	}
-	if results[0].User.Id != 1 {
-		slog.Info("results", "results", results)
-		t.Fatal("expected id 1, got", results[0].User.Id)
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM Membership").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatal("expected the memberships to be deleted, got", count)
+	}
+	if _, err := DeleteByPK[Membership](ctx, db, 1); err == nil {
+		t.Fatal("expected an error for a partial composite key")
 	}
 }
 
-func TestSelectAll(t *testing.T) {
+func TestUpdateByPK(t *testing.T) {
 	db := mock(t)
-	type Results struct {
-		User User
-	}
-	query, err := New[Results](`SELECT * FROM User`)
+	ctx := context.Background()
+	// only the name is updated, the nil uuid and createdAt are left as is
+	result, err := UpdateByPK(ctx, db, User{Id: 1, Name: &sql.NullString{String: "Jane Doe", Valid: true}}, WithColumns("name"))
 	if err != nil {
 		t.Fatal(err)
 	}
-	queryStmt, err := Prepare(query, db)
-	if err != nil {
-		t.Fatal(err)
+	if affected, _ := result.RowsAffected(); affected != 1 {
+		t.Fatal("expected 1 updated user, got", affected)
 	}
-	results, err := queryStmt.Query()
+	user, err := One(Must[User](`SELECT User.* FROM User WHERE User.id = 1`), db)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(results) != 1 {
-		t.Fatal("expected 1 result, got", len(results))
+	if user.Name.String != "Jane Doe" || user.CreatedAt == nil {
+		t.Fatal("expected only the name to be updated, got", user.Name, user.CreatedAt)
 	}
-	if results[0].User.Id != 1 {
-		t.Fatal("expected id 1, got", results[0].User.Id)
+	type Membership struct {
+		UserId    int    `tql:"userId,pk"`
+		AccountId int    `tql:"accountId,pk"`
+		Role      string `tql:"role"`
 	}
-}
-
-func TestTopLevelSelectAll(t *testing.T) {
-	db := mock(t)
-	query, err := New[User](`SELECT * FROM User`)
-	if err != nil {
+	if _, err := db.Exec(`CREATE TABLE Membership (userId INTEGER, accountId INTEGER, role TEXT, PRIMARY KEY (userId, accountId));
+		INSERT INTO Membership (userId, accountId, role) VALUES (1, 2, 'owner'), (1, 3, 'member')`); err != nil {
 		t.Fatal(err)
 	}
-	queryStmt, err := Prepare(query, db)
-	if err != nil {
+	if _, err := UpdateByPK(ctx, db, Membership{UserId: 1, AccountId: 3, Role: "owner"}); err != nil {
 		t.Fatal(err)
 	}
-	results, err := queryStmt.Query()
+	owners, err := QueryScalar[int](db, "SELECT COUNT(*) FROM Membership WHERE role = 'owner'")
+	if err != nil || owners != 2 {
+		t.Fatal("expected 2 owners, got", owners, err)
+	}
+	if _, err := UpdateByPK(ctx, db, Membership{UserId: 1, AccountId: 3}, WithColumns("userId")); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatal("expected ErrInvalidArgument for a primary key column, got", err)
+	}
+	if _, err := UpdateByPK(ctx, db, Membership{UserId: 1, AccountId: 3}, WithColumns("missing")); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatal("expected ErrInvalidArgument for an unknown column, got", err)
+	}
+	if _, err := UpdateByPK(ctx, db, Membership{UserId: 1, AccountId: 3}, WithColumns("role", "role")); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatal("expected ErrInvalidArgument for a column set twice, got", err)
+	}
+	if _, err := UpdateByPK(ctx, db, Membership{UserId: 1, AccountId: 3}, WithKey("role"), WithColumns("accountId")); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatal("expected ErrInvalidArgument for a primary key column with another key, got", err)
+	}
+	if _, err := UpdateByPK(ctx, db, Membership{UserId: 1, AccountId: 3}, WithKey("missing")); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatal("expected ErrInvalidArgument for an unknown key column, got", err)
+	}
+	// the role of every membership of the user is set
+	result, err = UpdateByPK(ctx, db, Membership{UserId: 1, Role: "admin"}, WithKey("userId"), WithColumns("role"))
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(results) != 1 {
-		t.Fatal("expected 1 result, got", len(results))
+	if affected, _ := result.RowsAffected(); affected != 2 {
+		t.Fatal("expected 2 updated memberships, got", affected)
 	}
-	if results[0].Id != 1 {
-		t.Fatal("expected id 1, got", results[0].Id)
+	recorder := &sqlRecorder{}
+	UpdateByPK(ctx, recorder, Membership{UserId: 1, AccountId: 3, Role: "owner"}, WithDialect(DialectPostgres))
+	if fmt.Sprint(recorder.sql) != "[UPDATE Membership SET role = $1 WHERE userId = $2 AND accountId = $3]" {
+		t.Fatal("unexpected sql", recorder.sql)
 	}
 }
 
-func TestSelectAllFromTable(t *testing.T) {
+// sqlRecorder is a Preparer that records the SQL it is asked to prepare and fails to prepare it
+type sqlRecorder struct {
+	sql []string
+}
+
+func (recorder *sqlRecorder) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	recorder.sql = append(recorder.sql, query)
+	return nil, errors.New("recorded")
+}
+
+func TestDeleteByPKOptions(t *testing.T) {
+	type Membership struct {
+		UserId    int `db:"user_id" tql:",pk"`
+		AccountId int `db:"account_id" tql:",pk"`
+	}
+	recorder := &sqlRecorder{}
+	DeleteByPK[Membership](context.Background(), recorder, []any{1, 2}, WithDialect(DialectPostgres), WithTagNames("db"))
+	if fmt.Sprint(recorder.sql) != "[DELETE FROM Membership WHERE user_id = $1 AND account_id = $2]" {
+		t.Fatal("unexpected sql", recorder.sql)
+	}
+}
+
+func TestNestedStructFields(t *testing.T) {
 	db := mock(t)
+	type Audit struct {
+		CreatedAt *time.Time `tql:"createdAt"`
+	}
+	type Base struct {
+		Id      int `tql:"id"`
+		Details struct {
+			Name  *sql.NullString `tql:"name"`
+			Audit Audit
+		}
+	}
 	type Results struct {
-		User    User
+		User    Base
 		Account Account
 	}
-	query, err := New[Results](`SELECT User.*, Account.id FROM User LEFT JOIN Account ON User.id = Account.userId`)
+	query, err := New[Results](`SELECT User.id, User.name, User.createdAt, Account.id FROM User JOIN Account ON Account.userId = User.id`)
 	if err != nil {
 		t.Fatal(err)
 	}
+	_, indices, err := Explain(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(indices) != "[[0 0] [0 1 0] [0 1 1 0] [1 0]]" {
+		t.Fatal("expected the index paths of the nested fields, got", indices)
+	}
 	results, err := Query(query, db)
 	if err != nil {
 		t.Fatal(err)
@@ -737,64 +898,2540 @@ func TestSelectAllFromTable(t *testing.T) {
 	if len(results) != 1 {
 		t.Fatal("expected 1 result, got", len(results))
 	}
-	if results[0].User.Id != 1 {
-		t.Fatal("expected id 1, got", results[0].User.Id)
+	if results[0].User.Id != 1 || results[0].User.Details.Name.String != "John Doe" || results[0].User.Details.Audit.CreatedAt == nil {
+		t.Fatal("expected the nested fields to be scanned, got", results[0].User)
 	}
 	if results[0].Account.Id != 2 {
-		t.Fatal("expected id 2, got", results[0].Account.Id)
+		t.Fatal("expected account 2, got", results[0].Account.Id)
 	}
 }
 
-func TestSelectAllFromTablWithOmit(t *testing.T) {
-	db := mock(t)
-	type Results struct {
-		User    User `tql:"omit=createdAt"`
-		Account Account
+func TestAmbiguousField(t *testing.T) {
+	type Base struct {
+		Id int `tql:"id"`
 	}
-	query, err := New[Results](`SELECT User.*, Account.id FROM User LEFT JOIN Account ON User.id = Account.userId`)
-	if err != nil {
-		t.Fatal(err)
+	type Audit struct {
+		Id int `tql:"id"`
 	}
-	results, err := Query(query, db)
+	type Ambiguous struct {
+		User struct {
+			Base
+			Audit
+		}
+	}
+	query, err := New[Ambiguous](`SELECT User.id FROM User`)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(results) != 1 {
-		t.Fatal("expected 1 result, got", len(results))
+	_, _, err = Explain(query)
+	if !errors.Is(err, ErrAmbiguousField) {
+		t.Fatal("expected ErrAmbiguousField, got", err)
 	}
-	if results[0].User.Id != 1 {
-		t.Fatal("expected id 1, got", results[0].User.Id)
+	if !strings.Contains(err.Error(), "Base.Id and Audit.Id") {
+		t.Fatal("expected the error to name the conflicting fields, got", err)
 	}
-	if results[0].Account.Id != 2 {
-		t.Fatal("expected id 2, got", results[0].Account.Id)
+	// a shallower field shadows the embedded one like Go promotion
+	type Shadowed struct {
+		User struct {
+			Base
+			Id int `tql:"id"`
+		}
+	}
+	sql, indices := Parse[Shadowed](`SELECT User.id FROM User`)
+	if sql != "SELECT User.id FROM User" || fmt.Sprint(indices) != "[[0 1]]" {
+		t.Fatal("expected the outer field to be selected, got", sql, indices)
 	}
 }
 
-func TestWithTransaction(t *testing.T) {
+// commaList scans a comma separated text column into a []string, like pq.Array scans a postgres array
+type commaList struct {
+	tags *[]string
+}
+
+func (list commaList) Scan(src any) error {
+	var text string
+	switch src := src.(type) {
+	case nil:
+		*list.tags = nil
+		return nil
+	case []byte:
+		text = string(src)
+	case string:
+		text = src
+	default:
+		return fmt.Errorf("unsupported type %T", src)
+	}
+	*list.tags = strings.Split(text, ",")
+	return nil
+}
+
+func TestRegisterScanner(t *testing.T) {
 	db := mock(t)
-	tx, err := db.Begin()
-	defer tx.Rollback()
-	if err != nil {
+	RegisterScanner(reflect.TypeFor[[]string](), func(field any) sql.Scanner {
+		return commaList{tags: field.(*[]string)}
+	})
+	defer func() {
+		scannersMu.Lock()
+		delete(scanners, reflect.TypeFor[[]string]())
+		scannersMu.Unlock()
+	}()
+	if _, err := db.Exec("UPDATE User SET uuid = 'admin,owner' WHERE id = 1"); err != nil {
 		t.Fatal(err)
 	}
 	type Results struct {
-		User User
+		Id   int      `tql:"id"`
+		Tags []string `tql:"uuid"`
 	}
-	query, err := New[Results](`SELECT User.id, User.name, User.createdAt FROM User where User.id = ?`)
+	results, err := Query(Must[Results](`SELECT User.id, User.uuid FROM User`), db)
 	if err != nil {
 		t.Fatal(err)
 	}
-	results, err := Query(query, tx, 1)
+	if len(results) != 1 || fmt.Sprint(results[0].Tags) != "[admin owner]" {
+		t.Fatal("expected the tags to be scanned by the registered scanner, got", results)
+	}
+}
+
+func TestJSONField(t *testing.T) {
+	db := mock(t)
+	if _, err := db.Exec(`UPDATE User SET uuid = '{"theme":"dark","tabs":2}' WHERE id = 1; INSERT INTO User (id, name) VALUES (2, 'Jane Doe')`); err != nil {
+		t.Fatal(err)
+	}
+	type Settings struct {
+		Theme string `json:"theme"`
+		Tabs  int    `json:"tabs"`
+	}
+	type Results struct {
+		Id       int            `tql:"id"`
+		Settings Settings       `tql:"uuid,json"`
+		Raw      map[string]any `tql:"uuid,json"`
+	}
+	results, err := Query(MustWithOptions[Results](`SELECT User.id, User.uuid, User.uuid FROM User ORDER BY User.id`, WithNoRewrite()), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatal("expected 2 results, got", len(results))
+	}
+	if results[0].Settings.Theme != "dark" || results[0].Settings.Tabs != 2 || results[0].Raw["theme"] != "dark" {
+		t.Fatal("expected the json to be decoded, got", results[0])
+	}
+	// a NULL column leaves the field zero instead of keeping the values of the previous row
+	if results[1].Settings.Theme != "" || results[1].Raw != nil {
+		t.Fatal("expected NULL to leave the fields zero, got", results[1])
+	}
+	if _, err := db.Exec(`UPDATE User SET uuid = 'not json' WHERE id = 2`); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Query(MustWithOptions[Results](`SELECT User.id, User.uuid, User.uuid FROM User ORDER BY User.id`, WithNoRewrite()), db)
+	if !errors.Is(err, ErrDecodingJSON) || !strings.Contains(err.Error(), "column Settings") {
+		t.Fatal("expected ErrDecodingJSON naming the column, got", err)
+	}
+}
+
+// recordingTracer records the spans it starts
+type recordingTracer struct {
+	spans *[]*recordingSpan
+}
+
+func (tracer recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &recordingSpan{name: name}
+	*tracer.spans = append(*tracer.spans, span)
+	return ctx, span
+}
+
+// recordingSpan records the statement, status and end of a span
+type recordingSpan struct {
+	name      string
+	statement string
+	failed    bool
+	ended     bool
+}
+
+func (span *recordingSpan) SetSQL(sql string) { span.statement = sql }
+
+func (span *recordingSpan) End(err error) {
+	span.failed = err != nil
+	span.ended = true
+}
+
+func TestWithTracer(t *testing.T) {
+	db := mock(t)
+	spans := []*recordingSpan{}
+	tracer := recordingTracer{spans: &spans}
+	query := MustWithOptions[User](`SELECT User.id FROM User WHERE User.id = {{ param .Id }}`, WithTracer(tracer))
+	stmt, err := Prepare(query, db, Params{"Id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	if _, err := stmt.Query(); err != nil {
+		t.Fatal(err)
+	}
+	insert := MustWithOptions[User](`INSERT INTO User (id, name) VALUES (?, ?)`, WithTracer(tracer))
+	if _, err := Exec(insert, db, 1, "duplicate"); err == nil {
+		t.Fatal("expected a duplicate key error")
+	}
+	names := []string{}
+	for _, span := range spans {
+		names = append(names, span.name)
+		if !span.ended {
+			t.Fatal("expected the span to be ended", span.name)
+		}
+	}
+	if strings.Join(names, " ") != "tql.prepare tql.driver.prepare tql.query tql.driver.query tql.prepare tql.driver.prepare tql.exec tql.driver.exec" {
+		t.Fatal("unexpected spans", names)
+	}
+	if spans[0].statement != "SELECT id FROM User WHERE User.id = ?" || spans[2].statement != spans[0].statement {
+		t.Fatal("expected the spans to record the sql with placeholders, got", spans[0].statement, spans[2].statement)
+	}
+	if spans[2].failed || !spans[6].failed || !spans[7].failed {
+		t.Fatal("expected only the failed exec to be marked as errored")
+	}
+}
+
+func TestRowsTracing(t *testing.T) {
+	db := mock(t)
+	var buf strings.Builder
+	defaultLog := log
+	log = slog.New(slog.NewTextHandler(&buf, nil)).WithGroup("tql")
+	defer func() { log = defaultLog }()
+	spans := []*recordingSpan{}
+	query := MustWithOptions[User](`SELECT User.id FROM User WHERE User.id = ?`, WithTracer(recordingTracer{spans: &spans}), WithSlowQueryThreshold(time.Nanosecond))
+	stmt, err := Prepare(query, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	spans = spans[:0]
+	rows, err := stmt.Rows(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	if len(spans) != 2 || spans[0].name != "tql.query" || spans[1].name != "tql.driver.query" || !spans[0].ended || !spans[1].ended {
+		t.Fatal("expected the query and driver spans, got", spans)
+	}
+	if spans[0].statement != "SELECT id FROM User WHERE User.id = ?" {
+		t.Fatal("expected the span to record the sql, got", spans[0].statement)
+	}
+	if !strings.Contains(buf.String(), "slow query") {
+		t.Fatal("expected the slow query to be logged, got", buf.String())
+	}
+}
+
+func TestWithSlowQueryThreshold(t *testing.T) {
+	db := mock(t)
+	var buf strings.Builder
+	defaultLog := log
+	log = slog.New(slog.NewTextHandler(&buf, nil)).WithGroup("tql")
+	defer func() { log = defaultLog }()
+	query := MustWithOptions[User](`SELECT User.id FROM User WHERE User.name = ?`, WithSlowQueryThreshold(time.Nanosecond))
+	if _, err := Query(query, db, "John Doe"); err != nil {
+		t.Fatal(err)
+	}
+	output := buf.String()
+	if !strings.Contains(output, "slow query") || !strings.Contains(output, `tql.sql="SELECT id FROM User WHERE User.name = ?"`) || !strings.Contains(output, "tql.args=1") {
+		t.Fatal("expected the slow query to be logged, got", output)
+	}
+	if strings.Contains(output, "John Doe") {
+		t.Fatal("expected the argument values not to be logged, got", output)
+	}
+	buf.Reset()
+	if _, err := Query(MustWithOptions[User](`SELECT User.id FROM User`, WithSlowQueryThreshold(time.Hour)), db); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "slow query") {
+		t.Fatal("expected a fast query not to be logged, got", buf.String())
+	}
+}
+
+func TestQueryRow(t *testing.T) {
+	db := mock(t)
+	if _, err := db.Exec("INSERT INTO User (id, name) VALUES (2, 'Jane Doe')"); err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := Prepare(Must[User](`SELECT User.id, User.name FROM User WHERE User.id >= ? ORDER BY User.id`), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	// the rows after the first one are ignored
+	user, err := stmt.QueryRow(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Id != 1 || user.Name.String != "John Doe" {
+		t.Fatal("expected user 1, got", user)
+	}
+	user, err = stmt.QueryRowContext(context.Background(), 2)
+	if err != nil || user.Id != 2 {
+		t.Fatal("expected user 2, got", user, err)
+	}
+	if _, err := stmt.QueryRow(42); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatal("expected sql.ErrNoRows, got", err)
+	}
+}
+
+func TestMustVariants(t *testing.T) {
+	db := mock(t)
+	MustExec(Must[User](`INSERT INTO User (id, name) VALUES (?, ?)`), db, 2, "Jane Doe")
+	users := MustQuery(Must[User](`SELECT User.id FROM User ORDER BY User.id`), db)
+	if len(users) != 2 || users[1].Id != 2 {
+		t.Fatal("expected 2 users, got", users)
+	}
+	stmt := MustPrepare(Must[User](`SELECT User.id FROM User WHERE User.id = ?`), db)
+	defer stmt.Close()
+	if user, err := stmt.QueryRow(2); err != nil || user.Id != 2 {
+		t.Fatal("expected user 2, got", user, err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected MustExec to panic on a duplicate key")
+		}
+	}()
+	MustExec(Must[User](`INSERT INTO User (id, name) VALUES (?, ?)`), db, 2, "Jane Doe")
+}
+
+func TestNoColumns(t *testing.T) {
+	db := mock(t)
+	_, err := Query(Must[User](`SELECT COUNT(*) AS total FROM User`), db)
+	if !errors.Is(err, ErrNoColumns) {
+		t.Fatal("expected ErrNoColumns, got", err)
+	}
+	// statements without a projection can still be executed
+	if _, err := Exec(Must[User](`UPDATE User SET name = ? WHERE id = ?`), db, "Jane Doe", 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReturning(t *testing.T) {
+	sql, indices := Parse[User](`INSERT INTO User (id, name) VALUES (?, ?) RETURNING *`)
+	if sql != "INSERT INTO User (id, name) VALUES (?, ?) RETURNING id, name, uuid, createdAt" || len(indices) != 4 {
+		t.Fatal("expected the returned columns to be expanded, got", sql, indices)
+	}
+	// the nested SELECT is not the projection of the statement
+	sql, indices = Parse[User](`UPDATE User SET name = ? WHERE id IN (SELECT userId FROM Account) RETURNING id, name`)
+	if sql != "UPDATE User SET name = ? WHERE id IN (SELECT userId FROM Account) RETURNING id, name" || fmt.Sprint(indices) != "[[0] [1]]" {
+		t.Fatal("expected the returned columns to be selected, got", sql, indices)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	type Results struct {
+		User    User
+		Account Account
+	}
+	query := Must[Results](`SELECT User.*, Account.id FROM User JOIN Account ON Account.userId = User.id WHERE User.id = {{ param .Id }}`)
+	sql, columns, indices, err := query.Analyze(Params{"Id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql != "SELECT User.id, User.name, User.uuid, User.createdAt, Account.id FROM User JOIN Account ON Account.userId = User.id WHERE User.id = ?" {
+		t.Fatal("unexpected sql", sql)
+	}
+	if fmt.Sprint(columns) != "[User.id User.name User.uuid User.createdAt Account.id]" {
+		t.Fatal("unexpected columns", columns)
+	}
+	if fmt.Sprint(indices) != "[[0 0] [0 1] [0 2] [0 3] [1 0]]" {
+		t.Fatal("unexpected indices", indices)
+	}
+	_, columns, _, err = Must[User](`SELECT name, id FROM User`).Analyze()
+	if err != nil || fmt.Sprint(columns) != "[id name]" {
+		t.Fatal("expected the single table columns in scan order, got", columns, err)
+	}
+}
+
+func TestNamedParam(t *testing.T) {
+	query := Must[User](`SELECT User.id FROM User WHERE User.id = {{ named "id" .Id }}{{ if .Name }} AND User.name = {{ named "name" .Name }}{{ end }} OR User.id = {{ named "id" .Id }} ORDER BY User.id = {{ named "id" .Id }}`)
+	generatedSQL, args, err := GenerateAndArgs(query, Params{"Id": 1, "Name": "John Doe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if generatedSQL != "SELECT id FROM User WHERE User.id = @id AND User.name = @name OR User.id = @id ORDER BY User.id = @id" {
+		t.Fatal("unexpected sql", generatedSQL)
+	}
+	if fmt.Sprint(args) != fmt.Sprint([]any{sql.Named("id", 1), sql.Named("name", "John Doe")}) {
+		t.Fatal("expected the repeated param to be bound once, got", args)
+	}
+	if _, _, err := GenerateAndArgs(Must[User](`SELECT User.id FROM User WHERE User.id = {{ named "id" 1 }} OR User.id = {{ named "id" 2 }}`)); err == nil {
+		t.Fatal("expected an error for a name bound to different values")
+	}
+	if _, _, err := GenerateAndArgs(Must[User](`SELECT User.id FROM User WHERE User.id = {{ named "User.id" 1 }}`)); !errors.Is(err, ErrInvalidIdentifier) {
+		t.Fatal("expected ErrInvalidIdentifier, got", err)
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if escaped := EscapeLike(`50%_off\`); escaped != `50\%\_off\\` {
+		t.Fatal("unexpected escaped value", escaped)
+	}
+	db := mock(t)
+	MustExec(Must[User](`INSERT INTO User (id, name) VALUES (?, ?), (?, ?)`), db, 2, "100% real", 3, "1000 real")
+	query := Must[User](`SELECT User.id FROM User WHERE User.name LIKE CONCAT('%', {{ param (like .Name) }}, '%')`)
+	stmt, err := Prepare(query, db, Params{"Name": "0%"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	users, err := stmt.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Id != 2 {
+		t.Fatal("expected only the literal match, got", users)
+	}
+}
+
+func TestWithDelims(t *testing.T) {
+	db := mock(t)
+	query, err := NewWithOptions[User](`SELECT User.id, User.name FROM User WHERE User.id = [[ param .Id ]] AND User.name <> '{{ literal }}'`, WithDelims("[[", "]]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := Prepare(query, db, Params{"Id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	if stmt.SQL != "SELECT id, name FROM User WHERE User.id = ? AND User.name <> '{{ literal }}'" {
+		t.Fatal("unexpected sql", stmt.SQL)
+	}
+	users, err := stmt.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Id != 1 {
+		t.Fatal("expected user 1, got", users)
+	}
+}
+
+func TestWithWhitespace(t *testing.T) {
+	db := mock(t)
+	query, err := NewWithOptions[User](`
+		SELECT User.id, User.name
+		FROM User
+		{{ if .Id }}
+			WHERE User.id = {{ param .Id }} -- the user
+			AND User.name <> 'John  Doe\n'
+		{{ end }}
+	`, WithWhitespace(WhitespaceCollapse))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := Prepare(query, db, Params{"Id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	if stmt.SQL != "SELECT id, name FROM User WHERE User.id = ? -- the user\nAND User.name <> 'John  Doe\\n'" {
+		t.Fatalf("unexpected sql %q", stmt.SQL)
+	}
+	if _, err := stmt.Query(); err != nil {
+		t.Fatal(err)
+	}
+	sql, _, err := query.Generate(Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql != "SELECT User.id, User.name FROM User" {
+		t.Fatalf("expected the generated sql to be collapsed, got %q", sql)
+	}
+}
+
+func TestDeterministicSQL(t *testing.T) {
+	query := MustWithOptions[User](`SELECT User.* FROM User WHERE 1 = 1{{ range $column, $value := .Filters }} AND User.{{ $column }} = {{ param $value }}{{ end }}`, WithDialect(DialectPostgres))
+	filters := map[string]any{}
+	for i := range 20 {
+		filters[fmt.Sprintf("c%02d", i)] = i
+	}
+	var expected strings.Builder
+	expected.WriteString("SELECT User.* FROM User WHERE 1 = 1")
+	for i := range 20 {
+		fmt.Fprintf(&expected, " AND User.c%02d = $%d", i, i+1)
+	}
+	for range 10 {
+		sql, args, err := query.Generate(Params{"Filters": filters})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sql != expected.String() {
+			t.Fatal("expected the map to be ranged in key order, got", sql)
+		}
+		for i, arg := range args {
+			if arg != i {
+				t.Fatal("expected the args in placeholder order, got", args)
+			}
+		}
+	}
+}
+
+func TestNestedParamOrder(t *testing.T) {
+	db := mock(t)
+	MustExec(Must[User](`INSERT INTO User (id, name) VALUES (?, ?)`), db, 2, "Jane Doe")
+	sub := Must[Account](`SELECT Account.userId FROM Account WHERE Account.id = {{ param .AccountId }}`)
+	query := Must[User](`SELECT User.id FROM User WHERE User.name = {{ param .Name }} AND User.id IN ({{ tql .Sub . }}) AND User.id <> {{ param .ExcludedId }}`)
+	data := Params{"Name": "John Doe", "Sub": sub, "AccountId": 2, "ExcludedId": 3}
+	sql, args, err := GenerateAndArgs(query, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql != "SELECT id FROM User WHERE User.name = ? AND User.id IN (SELECT Account.userId FROM Account WHERE Account.id = ?) AND User.id <> ?" {
+		t.Fatal("unexpected sql", sql)
+	}
+	if fmt.Sprint(args) != "[John Doe 2 3]" {
+		t.Fatal("expected the subquery args between the outer args, got", args)
+	}
+	stmt, err := Prepare(query, db, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	users, err := stmt.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Id != 1 {
+		t.Fatal("expected user 1, got", users)
+	}
+	postgres := MustWithOptions[User](`SELECT User.id FROM User WHERE User.name = {{ param .Name }} AND User.id IN ({{ tql .Sub . }}) AND User.id <> {{ param .ExcludedId }}`, WithDialect(DialectPostgres))
+	sql, args, err = GenerateAndArgs(postgres, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sql, "Account.id = $2) AND User.id <> $3") || fmt.Sprint(args) != "[John Doe 2 3]" {
+		t.Fatal("expected the subquery ordinals between the outer ordinals, got", sql, args)
+	}
+}
+
+func TestMultipleStatements(t *testing.T) {
+	db := mock(t)
+	query := Must[User](`SELECT User.id FROM User WHERE {{ .Where }}`)
+	_, err := Prepare(query, db, Params{"Where": "User.id = 1; DROP TABLE Account"})
+	if !errors.Is(err, ErrMultipleStatements) {
+		t.Fatal("expected ErrMultipleStatements, got", err)
+	}
+	// semicolons in strings and comments and a trailing semicolon are a single statement
+	stmt, err := Prepare(query, db, Params{"Where": "User.name <> 'a;b' /* ; */ AND User.name <> 'it\\'s;' -- ;\n;"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	users, err := stmt.Query()
+	if err != nil || len(users) != 1 {
+		t.Fatal("expected 1 user, got", users, err)
+	}
+	if _, err := Query(Must[Account](`SELECT Account.id FROM Account`), db); err != nil {
+		t.Fatal("expected the Account table to be kept, got", err)
+	}
+	_, err = Prepare(MustWithOptions[User](`SELECT User.id FROM User WHERE {{ .Where }}`, WithMultiStatement(true)), db, Params{"Where": "User.id = 1; SELECT 1"})
+	if errors.Is(err, ErrMultipleStatements) {
+		t.Fatal("expected multiple statements to be allowed, got", err)
+	}
+}
+
+func TestWithStrictParams(t *testing.T) {
+	db := mock(t)
+	query := MustWithOptions[User](`SELECT uuid, name FROM User WHERE User.name = '{{ .name }}'`, WithStrictParams())
+	_, err := Prepare(query, db, Params{"name": "John Doe"})
+	if !errors.Is(err, ErrInterpolatedValue) {
+		t.Fatal("expected ErrInterpolatedValue, got", err)
+	}
+	// actions outside of string literals, including in comments and after escaped quotes, are allowed
+	query = MustWithOptions[User](`SELECT {{ .Columns }} FROM User WHERE User.name <> 'it\'s' -- don't {{ .Comment }}
+		{{ if .Name }}AND User.name = {{ param .Name }}{{ end }}`, WithStrictParams())
+	stmt, err := Prepare(query, db, Params{"Columns": "User.id, User.name", "Comment": "'", "Name": "John Doe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	if strings.Contains(stmt.SQL, "\x00") {
+		t.Fatal("expected the markers to be removed, got", stmt.SQL)
+	}
+	users, err := stmt.Query()
+	if err != nil || len(users) != 1 {
+		t.Fatal("expected 1 user, got", users, err)
+	}
+}
+
+func TestGenerateStruct(t *testing.T) {
+	db := mock(t)
+	source, err := GenerateStruct(context.Background(), db, "SELECT * FROM User WHERE User.id = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "type User struct {\n" +
+		"\tId        int64          `tql:\"id\"`\n" +
+		"\tName      sql.NullString `tql:\"name\"`\n" +
+		"\tCreatedAt sql.NullTime   `tql:\"createdAt\"`\n" +
+		"\tUuid      sql.NullString `tql:\"uuid\"`\n" +
+		"}\n"
+	if source != expected {
+		t.Fatal("unexpected source", source)
+	}
+}
+
+func TestGenerateStructNames(t *testing.T) {
+	db := mock(t)
+	if _, err := db.Exec("INSERT INTO Account (id, userId) VALUES (5, 1)"); err != nil {
+		t.Fatal(err)
+	}
+	// the id column of both tables gets a numbered field
+	source, err := GenerateStruct(context.Background(), db, "SELECT User.id, Account.id, User.createdAt FROM User JOIN Account ON Account.userId = User.id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "type User struct {\n" +
+		"\tId        int64        `tql:\"id\"`\n" +
+		"\tId2       int64        `tql:\"id\"`\n" +
+		"\tCreatedAt sql.NullTime `tql:\"createdAt\"`\n" +
+		"}\n"
+	if source != expected {
+		t.Fatal("unexpected source", source)
+	}
+	// a query without a table generates a struct named Result
+	source, err = GenerateStruct(context.Background(), db, "SELECT 1 AS one, CAST('2024-01-02 03:04:05' AS DATETIME) AS at")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "type Result struct {\n" +
+		"\tOne int64        `tql:\"one\"`\n" +
+		"\tAt  sql.NullTime `tql:\"at\"`\n" +
+		"}\n"
+	if source != expected {
+		t.Fatal("unexpected source", source)
+	}
+}
+
+func TestDatabaseGoType(t *testing.T) {
+	for _, test := range []struct {
+		databaseType string
+		nullable     bool
+		goType       string
+	}{
+		{"INT", false, "int64"},
+		{"BIGINT UNSIGNED", true, "sql.NullInt64"},
+		{"UNSIGNED INT", false, "int64"},
+		{"INT4", false, "int64"},
+		{"SERIAL", false, "int64"},
+		{"POINT", false, "string"},
+		{"INTERVAL", true, "sql.NullString"},
+		{"DECIMAL", false, "string"},
+		{"NUMERIC(10,2)", true, "sql.NullString"},
+		{"DOUBLE PRECISION", false, "float64"},
+		{"FLOAT8", false, "float64"},
+		{"TIMESTAMP WITH TIME ZONE", false, "time.Time"},
+		{"DATETIME", true, "sql.NullTime"},
+		{"BOOLEAN", false, "bool"},
+		{"VARBINARY", true, "[]byte"},
+		{"TEXT", false, "string"},
+	} {
+		if goType := databaseGoType(test.databaseType, test.nullable); goType != test.goType {
+			t.Fatal("expected", test.goType, "for", test.databaseType, "got", goType)
+		}
+	}
+}
+
+func TestScanRows(t *testing.T) {
+	db := mock(t)
+	rows, err := db.Query("SELECT id, name, 1 AS extra FROM User; SELECT id FROM Account")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	users, err := ScanRows[User](rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Id != 1 || users[0].Name.String != "John Doe" {
+		t.Fatal("expected John Doe, got", users)
+	}
+	if !rows.NextResultSet() {
+		t.Fatal("expected a second result set")
+	}
+	accounts, err := ScanRows[Account](rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 1 || accounts[0].Id != 2 {
+		t.Fatal("expected account 2, got", accounts)
+	}
+	stmt, err := Prepare(Must[User](`SELECT User.id FROM User WHERE User.id = ?`), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	stmtRows, err := stmt.Rows(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmtRows.Close()
+	if users, err := ScanRows[User](stmtRows); err != nil || len(users) != 1 || users[0].Id != 1 {
+		t.Fatal("expected user 1, got", users, err)
+	}
+}
+
+func TestNullIntoNonNullable(t *testing.T) {
+	db := mock(t)
+	type Plain struct {
+		Id   int    `tql:"id"`
+		UUID string `tql:"uuid"`
+	}
+	_, err := Query(Must[Plain](`SELECT id, uuid FROM User`), db)
+	if !errors.Is(err, ErrNullIntoNonNullable) {
+		t.Fatal("expected ErrNullIntoNonNullable, got", err)
+	}
+	if !strings.Contains(err.Error(), "column uuid into field UUID") {
+		t.Fatal("expected the error to name the column and the field, got", err)
+	}
+	results, err := Query(MustWithOptions[Plain](`SELECT id, uuid FROM User`, WithNullAsZero()), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Id != 1 || results[0].UUID != "" {
+		t.Fatal("expected the NULL to be scanned as the zero value, got", results)
+	}
+	// a nullable field still tells a NULL apart
+	users, err := Query(MustWithOptions[User](`SELECT id, uuid FROM User`, WithNullAsZero()), db)
+	if err != nil || len(users) != 1 || users[0].UUID != nil && users[0].UUID.Valid {
+		t.Fatal("expected a NULL uuid, got", users, err)
+	}
+}
+
+func TestRowsErr(t *testing.T) {
+	db := mock(t)
+	MustExec(Must[User](`INSERT INTO User (id, name) VALUES (?, ?), (?, ?)`), db, 2, "Jane Doe", 3, "Billy Joel")
+	stmt, err := Prepare(Must[User](`SELECT User.id FROM User ORDER BY User.id`), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	scanned := 0
+	err = stmt.scanEach(ctx, -1, func(User) {
+		scanned++
+		// the rows are closed with the error of the context while they are iterated
+		cancel()
+		time.Sleep(50 * time.Millisecond)
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("expected the error of the rows, got", err, "after", scanned, "rows")
+	}
+}
+
+func TestRowsClosedOnScanError(t *testing.T) {
+	db := mock(t)
+	db.SetMaxOpenConns(2)
+	type Plain struct {
+		Id   int    `tql:"id"`
+		UUID string `tql:"uuid"`
+	}
+	query := Must[Plain](`SELECT id, uuid FROM User`)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for i := 0; i < 50; i++ {
+		// a leaked connection would block the next query once the pool is exhausted
+		if _, err := QueryContext(query, ctx, db); !errors.Is(err, ErrNullIntoNonNullable) {
+			t.Fatal("expected ErrNullIntoNonNullable, got", err)
+		}
+	}
+	if inUse := db.Stats().InUse; inUse != 0 {
+		t.Fatal("expected every connection to be released, got", inUse)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	primary := mock(t)
+	replica, err := sql.Open("mysql", "root:@tcp(localhost:3306)/runpod?multiStatements=true&parseTime=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replica.Close()
+	plan, err := Must[User](`SELECT * FROM User WHERE User.id = {{ param .Id }}`).Plan(Params{"Id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.SQL() != "SELECT id, name, uuid, createdAt FROM User WHERE User.id = ?" {
+		t.Fatal("unexpected sql", plan.SQL())
+	}
+	for _, db := range []*sql.DB{primary, replica} {
+		stmt, err := plan.Prepare(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users, err := stmt.Query()
+		stmt.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != 1 || users[0].Id != 1 {
+			t.Fatal("expected user 1, got", users)
+		}
+	}
+}
+
+func TestOmitList(t *testing.T) {
+	type Results struct {
+		User    User    `tql:"omit=createdAt,User.uuid"`
+		Account Account `tql:"omit=Account.id"`
+	}
+	sql, indices := Parse[Results](`SELECT User.*, Account.* FROM User JOIN Account ON Account.userId = User.id`)
+	if sql != "SELECT User.id, User.name FROM User JOIN Account ON Account.userId = User.id" {
+		t.Fatal("unexpected sql", sql)
+	}
+	if fmt.Sprint(indices) != "[[0 0] [0 1]]" {
+		t.Fatal("expected both fields to be omitted, got", indices)
+	}
+	// a name is only omitted as a whole, omitting createdAt doesn't omit a created column
+	type Created struct {
+		Created   string `tql:"created"`
+		CreatedAt string `tql:"createdAt"`
+	}
+	type CreatedResults struct {
+		Created Created `tql:"User;omit=createdAt"`
+	}
+	_, indices = Parse[CreatedResults](`SELECT User.* FROM User`)
+	if fmt.Sprint(indices) != "[[0 0]]" {
+		t.Fatal("expected only createdAt to be omitted, got", indices)
+	}
+}
+
+func TestExprField(t *testing.T) {
+	db := mock(t)
+	type Named struct {
+		Id       int    `tql:"id"`
+		FullName string `tql:"fullName,expr"`
+	}
+	type Results struct {
+		User    Named   `tql:"User"`
+		Account Account `tql:"Account"`
+	}
+	query, err := New[Results](`SELECT User.id, CONCAT(User.name, '!') AS fullName, Account.id FROM User JOIN Account ON Account.userId = User.id WHERE User.id = 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := Prepare(query, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	if stmt.SQL != "SELECT User.id, CONCAT(User.name, '!') AS fullName, Account.id FROM User JOIN Account ON Account.userId = User.id WHERE User.id = 1" {
+		t.Fatal("expected the computed column to be kept as written, got", stmt.SQL)
+	}
+	results, err := stmt.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) == 0 || results[0].User.FullName != "John Doe!" {
+		t.Fatal("expected the computed column to be scanned, got", results)
+	}
+	// a computed column isn't a column of the table, selecting User.* doesn't select it
+	_, indices := Parse[Results](`SELECT User.*, Account.* FROM User JOIN Account ON Account.userId = User.id`)
+	if fmt.Sprint(indices) != "[[0 0] [1 0]]" {
+		t.Fatal("expected the computed column to be skipped, got", indices)
+	}
+}
+
+func TestTimeColumnFirst(t *testing.T) {
+	db := mock(t)
+	// a time.Time value is a struct but it is a column, so this is a single table struct
+	type Event struct {
+		CreatedAt time.Time `tql:"createdAt"`
+		Id        int       `tql:"id"`
+	}
+	query, err := New[Event](`SELECT * FROM User WHERE User.id = 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := Prepare(query, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	if stmt.SQL != "SELECT createdAt, id FROM User WHERE User.id = 1" {
+		t.Fatal("unexpected sql", stmt.SQL)
+	}
+	events, err := stmt.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Id != 1 || events[0].CreatedAt.IsZero() {
+		t.Fatal("expected the createdAt column to be scanned, got", events)
+	}
+}
+
+func TestColumnNextToTables(t *testing.T) {
+	db := mock(t)
+	// At is a struct but it is a column next to the User table
+	type Result struct {
+		User User      `tql:"User"`
+		At   time.Time `tql:"at"`
+	}
+	if !isScalarStruct(reflect.TypeFor[time.Time]()) || !isScalarStruct(reflect.TypeFor[sql.NullString]()) || isScalarStruct(reflect.TypeFor[User]()) {
+		t.Fatal("expected time.Time and sql.NullString to be scalar structs and User not to be")
+	}
+	query, err := New[Result](`SELECT User.*, NOW() AS at FROM User WHERE User.id = 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := Prepare(query, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	if stmt.SQL != "SELECT User.id, User.name, User.uuid, User.createdAt, NOW() AS at FROM User WHERE User.id = 1" {
+		t.Fatal("unexpected sql", stmt.SQL)
+	}
+	results, err := stmt.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].User.Id != 1 || results[0].At.IsZero() {
+		t.Fatal("expected the user and the at column, got", results)
+	}
+	if err := query.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExecBatch(t *testing.T) {
+	db := mock(t)
+	stmt, err := Prepare(Must[User](`INSERT INTO User (id, name) VALUES (?, ?)`), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	results, err := stmt.ExecBatch([][]any{{2, "Jane Doe"}, {3, "Jim Doe"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatal("expected a result per param set, got", results)
+	}
+	// the duplicate id stops the batch before the last param set
+	results, err = stmt.ExecBatch([][]any{{4, "Joe Doe"}, {1, "John Doe"}, {5, "Jo Doe"}})
+	if err == nil || !strings.Contains(err.Error(), "param set 1") || len(results) != 1 {
+		t.Fatal("expected the batch to stop on param set 1, got", results, err)
+	}
+	collecting, err := Prepare(MustWithOptions[User](`INSERT INTO User (id, name) VALUES (?, ?)`, WithCollectBatchErrors()), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer collecting.Close()
+	results, err = collecting.ExecBatch([][]any{{1, "John Doe"}, {5, "Jo Doe"}, {2, "Jane Doe"}})
+	if err == nil || !strings.Contains(err.Error(), "param set 0") || !strings.Contains(err.Error(), "param set 2") {
+		t.Fatal("expected the errors of param sets 0 and 2, got", err)
+	}
+	if len(results) != 3 || results[0] != nil || results[1] == nil {
+		t.Fatal("expected a result per param set, got", results)
+	}
+	users, err := Query(Must[User](`SELECT User.id FROM User ORDER BY User.id`), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 5 {
+		t.Fatal("expected 5 users, got", users)
+	}
+}
+
+func TestQueryPtr(t *testing.T) {
+	db := mock(t)
+	MustExec(Must[User](`INSERT INTO User (id, name) VALUES (?, ?)`), db, 2, "Jane Doe")
+	stmt, err := Prepare(Must[User](`SELECT User.id, User.name FROM User ORDER BY User.id`), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	users, err := stmt.QueryPtr()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].Id != 1 || users[1].Id != 2 || users[1].Name.String != "Jane Doe" {
+		t.Fatal("expected users 1 and 2, got", users)
+	}
+	// the rows are separate allocations
+	users[0].Id = 3
+	if users[1].Id != 2 {
+		t.Fatal("expected the rows not to alias each other, got", users[1].Id)
+	}
+}
+
+func TestStmtInvalidated(t *testing.T) {
+	db := mock(t)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := Prepare(Must[User](`SELECT User.id FROM User WHERE User.id = ?`), tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stmt.Query(1); !errors.Is(err, ErrStmtInvalidated) {
+		t.Fatal("expected ErrStmtInvalidated, got", err)
+	}
+	if _, err := stmt.Exec(1); !errors.Is(err, ErrStmtInvalidated) {
+		t.Fatal("expected ErrStmtInvalidated, got", err)
+	}
+}
+
+func TestStmt(t *testing.T) {
+	db := mock(t)
+	query := Must[User](`SELECT User.id, User.name FROM User WHERE User.id = {{ param .Id }} AND User.name = ?`)
+	stmt, err := Prepare(query, db, Params{"Id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := stmt.Stmt().Query(stmt.Args("John Doe")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(columnTypes) != 2 || columnTypes[1].Name() != "name" {
+		t.Fatal("expected the column types of the transformed sql, got", columnTypes)
+	}
+	if !rows.Next() {
+		t.Fatal("expected user 1")
+	}
+	rows.Close()
+	stmt.Close()
+	if stmt.Stmt() != nil {
+		t.Fatal("expected no statement after Close, got", stmt.Stmt())
+	}
+}
+
+func TestArgCountMismatch(t *testing.T) {
+	db := mock(t)
+	query := Must[User](`SELECT User.id FROM User WHERE User.id = ? AND User.name = ? AND User.name <> '?' -- ?`)
+	_, err := Query(query, db, 1)
+	if !errors.Is(err, ErrArgCountMismatch) || !strings.Contains(err.Error(), "expected 2 arguments, got 1") {
+		t.Fatal("expected ErrArgCountMismatch with both counts, got", err)
+	}
+	if _, err := Query(query, db, 1, "John Doe"); err != nil {
+		t.Fatal(err)
+	}
+	if count := countPlaceholders(`SELECT * FROM User WHERE id = $1 OR parentId = $1 AND name = $2 AND note <> '$3'`, DialectPostgres); count != 2 {
+		t.Fatal("expected a repeated ordinal to be counted once, got", count)
+	}
+	if count := countPlaceholders(`SELECT * FROM User WHERE id = ?1`, DialectMySQL); count != -1 {
+		t.Fatal("expected numbered placeholders not to be counted, got", count)
+	}
+}
+
+func TestWithPositionalScan(t *testing.T) {
+	db := mock(t)
+	type Row struct {
+		Key   int
+		Label string
+	}
+	query, err := NewWithOptions[Row](`SELECT id, CONCAT(name, '!') FROM User WHERE id = 1`, WithPositionalScan())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := query.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := Query(query, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Key != 1 || rows[0].Label != "John Doe!" {
+		t.Fatal("expected the columns to be scanned by position, got", rows)
+	}
+	short := MustWithOptions[Row](`SELECT id FROM User`, WithPositionalScan())
+	if _, err := Query(short, db); !errors.Is(err, ErrColumnMismatch) {
+		t.Fatal("expected ErrColumnMismatch, got", err)
+	}
+	if err := short.Validate(); !errors.Is(err, ErrColumnMismatch) {
+		t.Fatal("expected ErrColumnMismatch, got", err)
+	}
+	// a projection with a * is still matched by name
+	users, err := Query(MustWithOptions[User](`SELECT * FROM User`, WithPositionalScan()), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Id != 1 {
+		t.Fatal("expected user 1, got", users)
+	}
+}
+
+func TestQuoteIdent(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		dialect  Dialect
+		expected string
+	}{
+		{"User", DialectMySQL, "`User`"},
+		{"a`b", DialectMySQL, "`a``b`"},
+		{`a"b`, DialectMySQL, "`a\"b`"},
+		{"User.id", DialectPostgres, `"User"."id"`},
+		{`a"b`, DialectPostgres, `"a""b"`},
+	} {
+		if quoted, err := QuoteIdent(test.name, test.dialect); err != nil || quoted != test.expected {
+			t.Fatal("expected", test.expected, "got", quoted, err)
+		}
+	}
+	for _, name := range []string{"", "a\x00b", "User."} {
+		if _, err := QuoteIdent(name, DialectMySQL); !errors.Is(err, ErrInvalidIdentifier) {
+			t.Fatal("expected ErrInvalidIdentifier for", strconv.Quote(name), "got", err)
+		}
+	}
+	scratch := []byte("SELECT * FROM ")
+	scratch, err := AppendQuoteIdent(scratch, "User", DialectPostgres)
+	if err != nil || string(scratch) != `SELECT * FROM "User"` {
+		t.Fatal("unexpected sql", string(scratch), err)
+	}
+	if scratch, err = AppendQuoteIdent(scratch, "a\x00", DialectPostgres); err == nil || string(scratch) != `SELECT * FROM "User"` {
+		t.Fatal("expected the buffer to be left unchanged on error, got", string(scratch), err)
+	}
+}
+
+func TestLitAndIdent(t *testing.T) {
+	db := mock(t)
+	query := MustWithOptions[User](`SELECT User.id, User.name FROM User WHERE User.name <> {{ lit .Name }} ORDER BY {{ ident .Column }} LIMIT {{ lit .Limit }}`, WithStrictParams())
+	stmt, err := Prepare(query, db, Params{"Name": `O'Brien \' OR 1=1 --`, "Column": "User.name", "Limit": 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	if stmt.SQL != "SELECT id, name FROM User WHERE User.name <> 'O''Brien \\\\'' OR 1=1 --' ORDER BY `User`.`name` LIMIT 10" {
+		t.Fatal("unexpected sql", stmt.SQL)
+	}
+	users, err := stmt.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Id != 1 {
+		t.Fatal("expected user 1, got", users)
+	}
+	postgres := MustWithOptions[User](`SELECT * FROM "User" ORDER BY {{ ident .Column }}, {{ lit .At }}, {{ lit .Data }}, {{ lit .Missing }}`, WithDialect(DialectPostgres))
+	sql, _, err := postgres.Generate(Params{"Column": `a"b`, "At": time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), "Data": []byte("hi"), "Missing": (*int)(nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql != `SELECT * FROM "User" ORDER BY "a""b", '2024-01-02 03:04:05+00', X'6869', NULL` {
+		t.Fatal("unexpected sql", sql)
+	}
+	if _, _, err := postgres.Generate(Params{"Column": "", "At": 1, "Data": 1, "Missing": 1}); !errors.Is(err, ErrInvalidIdentifier) {
+		t.Fatal("expected ErrInvalidIdentifier, got", err)
+	}
+	if _, err := QuoteLiteral(func() {}, DialectMySQL); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatal("expected ErrInvalidArgument, got", err)
+	}
+	// strings follow the quoting rules of the dialect
+	for _, test := range []struct {
+		value    string
+		mysql    string
+		postgres string
+	}{
+		{"plain", "'plain'", "'plain'"},
+		{"O'Brien", "'O''Brien'", "'O''Brien'"},
+		{`a\b`, `'a\\b'`, `'a\b'`},
+		{"a\nb", "'a\nb'", `E'a\nb'`},
+		{"it's\t\\", "'it''s\t\\\\'", `E'it''s\t\\'`},
+		{"bell\a", "'bell\a'", `E'bell\u0007'`},
+		{"héllo", "'héllo'", "'héllo'"},
+	} {
+		if literal, err := QuoteLiteral(test.value, DialectMySQL); err != nil || literal != test.mysql {
+			t.Fatal("expected", test.mysql, "got", literal, err)
+		}
+		if literal, err := QuoteLiteral(test.value, DialectPostgres); err != nil || literal != test.postgres {
+			t.Fatal("expected", test.postgres, "got", literal, err)
+		}
+	}
+	// slices and arrays are IN lists
+	type Raw []byte
+	for _, test := range []struct {
+		value    any
+		expected string
+	}{
+		{[]int{1, 2, 3}, "(1, 2, 3)"},
+		{[]string{"a", "b'c"}, "('a', 'b''c')"},
+		{[2]bool{true, false}, "(TRUE, FALSE)"},
+		{[]any{1, nil, "x"}, "(1, NULL, 'x')"},
+		{[]int{}, "(NULL)"},
+		{Raw("hi"), "X'6869'"},
+	} {
+		if literal, err := QuoteLiteral(test.value, DialectMySQL); err != nil || literal != test.expected {
+			t.Fatal("expected", test.expected, "got", literal, err)
+		}
+	}
+	if _, err := QuoteLiteral([]float64{math.NaN()}, DialectMySQL); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatal("expected ErrInvalidArgument for a NaN element, got", err)
+	}
+	sql, _, err = MustWithOptions[User](`SELECT User.id FROM User WHERE User.id IN {{ lit .Ids }}`, WithDialect(DialectPostgres)).Generate(Params{"Ids": []int{1, 2}})
+	if err != nil || sql != "SELECT User.id FROM User WHERE User.id IN (1, 2)" {
+		t.Fatal("unexpected sql", sql, err)
+	}
+	// pointers are formatted like the value they point to and a nil pointer is NULL
+	name, id := "x", 7
+	for value, expected := range map[any]string{(*int)(nil): "NULL", (*string)(nil): "NULL", &name: "'x'", &id: "7"} {
+		if literal, err := QuoteLiteral(value, DialectMySQL); err != nil || literal != expected {
+			t.Fatal("expected", expected, "got", literal, err)
+		}
+	}
+	// named types are formatted by their kind
+	type Celsius float64
+	type UserId int64
+	type Status string
+	for value, expected := range map[any]string{Celsius(36.6): "36.6", UserId(7): "7", Status("it's"): "'it''s'", true: "TRUE"} {
+		if literal, err := QuoteLiteral(value, DialectMySQL); err != nil || literal != expected {
+			t.Fatal("expected", expected, "got", literal, err)
+		}
+	}
+	// maps and structs are JSON objects
+	type Settings struct {
+		Theme  string `json:"theme"`
+		Hidden string `json:"-"`
+	}
+	if literal, _ := QuoteLiteral(map[string]any{"name": "O'Brien"}, DialectMySQL); literal != `'{"name":"O''Brien"}'` {
+		t.Fatal("expected a JSON literal, got", literal)
+	}
+	if literal, _ := QuoteLiteral(&Settings{Theme: "dark", Hidden: "x"}, DialectPostgres); literal != `'{"theme":"dark"}'` {
+		t.Fatal("expected a JSON literal, got", literal)
+	}
+	if _, err := QuoteLiteral(map[string]any{"f": func() {}}, DialectMySQL); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatal("expected ErrInvalidArgument, got", err)
+	}
+}
+
+func TestWithFloatFormat(t *testing.T) {
+	params := Params{"Pi": 3.14159265358979, "Large": 1e21}
+	sql, _, err := Must[User](`SELECT {{ lit .Pi }}, {{ lit .Large }}`).Generate(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql != "SELECT 3.14159265358979, 1e+21" {
+		t.Fatal("expected the shortest representation by default, got", sql)
+	}
+	sql, _, err = MustWithOptions[User](`SELECT {{ lit .Pi }}, {{ lit .Large }}`, WithFloatFormat('f', 2)).Generate(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql != "SELECT 3.14, 1000000000000000000000.00" {
+		t.Fatal("expected fixed-point floats, got", sql)
+	}
+	for _, float := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if _, err := QuoteLiteral(float, DialectMySQL); !errors.Is(err, ErrInvalidArgument) {
+			t.Fatal("expected ErrInvalidArgument, got", err)
+		}
+	}
+}
+
+func TestQuoteDecimal(t *testing.T) {
+	if literal, _ := QuoteDecimal(big.NewRat(1, 3), 4); literal != "0.3333" {
+		t.Fatal("expected 1/3 rounded to 4 digits, got", literal)
+	}
+	if _, err := QuoteDecimal(big.NewRat(1, 3), -1); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatal("expected ErrInvalidArgument for 1/3 without a precision, got", err)
+	}
+	// 0.1 + 0.2 is exact as a rational
+	sum := new(big.Rat).Add(big.NewRat(1, 10), big.NewRat(2, 10))
+	if literal, _ := QuoteLiteral(sum, DialectMySQL); literal != "0.3" {
+		t.Fatal("expected an exact decimal, got", literal)
+	}
+	amount, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	if literal, _ := QuoteLiteral(amount, DialectPostgres); literal != "123456789012345678901234567890" {
+		t.Fatal("expected the exact integer, got", literal)
+	}
+	if literal, _ := QuoteDecimal(big.NewFloat(2.5), 2); literal != "2.50" {
+		t.Fatal("expected 2.50, got", literal)
+	}
+	if literal, _ := QuoteLiteral((*big.Rat)(nil), DialectMySQL); literal != "NULL" {
+		t.Fatal("expected NULL, got", literal)
+	}
+	if _, err := QuoteDecimal(1.5, 2); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatal("expected ErrInvalidArgument for a float64, got", err)
+	}
+	// a rune is a number for QuoteLiteral and a character for QuoteRune
+	if literal, _ := QuoteLiteral('A', DialectMySQL); literal != "65" {
+		t.Fatal("expected the rune to be formatted as a number, got", literal)
+	}
+	if literal, _ := QuoteRune('\'', DialectPostgres); literal != `''''` {
+		t.Fatal("expected the quote to be escaped, got", literal)
+	}
+	if literal, _ := QuoteRune('é', DialectMySQL); literal != "'é'" {
+		t.Fatal("expected a character literal, got", literal)
+	}
+	if _, err := QuoteRune(0xD800, DialectMySQL); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatal("expected ErrInvalidArgument, got", err)
+	}
+}
+
+func TestCountAlias(t *testing.T) {
+	db := mock(t)
+	type Count struct {
+		Total int `tql:"total"`
+	}
+	for _, sql := range []string{
+		"SELECT COUNT(*) AS total FROM User",
+		"SELECT COUNT(*) total FROM User",
+		"SELECT COUNT(*) AS `total` FROM User",
+	} {
+		counts, err := Query(Must[Count](sql), db)
+		if err != nil {
+			t.Fatal(sql, err)
+		}
+		if len(counts) != 1 || counts[0].Total != 1 {
+			t.Fatal(sql, "expected a total of 1, got", counts)
+		}
+	}
+	for column, name := range map[string]string{
+		"User.id uid":                            "uid",
+		"CASE WHEN id = 1 THEN 'a' ELSE (2) END": "CASE WHEN id = 1 THEN 'a' ELSE (2) END",
+		"NOT active":                             "NOT active",
+		"a + b":                                  "a + b",
+	} {
+		if columnName(column) != name {
+			t.Fatal("expected the name of", column, "to be", name, "got", columnName(column))
+		}
+	}
+}
+
+func TestQueryScalar(t *testing.T) {
+	db := mock(t)
+	count, err := QueryScalar[int](db, "SELECT COUNT(*) FROM User WHERE id >= ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatal("expected a count of 1, got", count)
+	}
+	exists, err := QueryScalarContext[bool](context.Background(), db, "SELECT EXISTS(SELECT 1 FROM User WHERE id = 1)")
+	if err != nil || !exists {
+		t.Fatal("expected user 1 to exist, got", exists, err)
+	}
+	createdAt, err := QueryScalar[time.Time](db, "SELECT createdAt FROM User WHERE id = 1")
+	if err != nil || createdAt.IsZero() {
+		t.Fatal("expected the createdAt of user 1, got", createdAt, err)
+	}
+	if _, err := QueryScalar[string](db, "SELECT name FROM User WHERE id = 42"); !errors.Is(err, ErrNoRows) {
+		t.Fatal("expected ErrNoRows, got", err)
+	}
+	if _, err := QueryScalar[int](db, "SELECT id, name FROM User"); !errors.Is(err, ErrColumnMismatch) {
+		t.Fatal("expected ErrColumnMismatch, got", err)
+	}
+	if _, err := QueryScalar[string](db, "SELECT uuid FROM User WHERE id = 1"); !errors.Is(err, ErrNullIntoNonNullable) {
+		t.Fatal("expected ErrNullIntoNonNullable, got", err)
+	}
+	MustExec(Must[User](`INSERT INTO User (id, name) VALUES (?, ?)`), db, 2, "Jane Doe")
+	if _, err := QueryScalar[int](db, "SELECT id FROM User"); !errors.Is(err, ErrMultipleRows) {
+		t.Fatal("expected ErrMultipleRows, got", err)
+	}
+}
+
+func TestFirst(t *testing.T) {
+	db := mock(t)
+	query, err := New[User](`SELECT User.id, User.name FROM User WHERE User.id = ?`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, found, err := First(query, db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || user.Id != 1 {
+		t.Fatal("expected user 1, got", user, found)
+	}
+	user, found, err = First(query, db, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found || user.Id != 0 {
+		t.Fatal("expected no user, got", user, found)
+	}
+}
+
+func TestOne(t *testing.T) {
+	db := mock(t)
+	if _, err := db.Exec("INSERT INTO User (id, name) VALUES (2, 'John Doe')"); err != nil {
+		t.Fatal(err)
+	}
+	query, err := New[User](`SELECT User.id, User.name FROM User WHERE User.name = ? ORDER BY User.id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := One(query, db, "John Doe"); !errors.Is(err, ErrMultipleRows) {
+		t.Fatal("expected ErrMultipleRows, got", err)
+	}
+	if _, err := One(query, db, "Jane Doe"); !errors.Is(err, ErrNoRows) {
+		t.Fatal("expected ErrNoRows, got", err)
+	}
+	byId, err := New[User](`SELECT User.id, User.name FROM User WHERE User.id = ?`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := One(byId, db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Id != 2 {
+		t.Fatal("expected id 2, got", user.Id)
+	}
+}
+
+func TestColumnMismatch(t *testing.T) {
+	db := mock(t)
+	type Results struct {
+		Id int `tql:"id"`
+	}
+	query, err := NewWithOptions[Results](`SELECT User.id, User.name, User.uuid FROM User`, WithNoRewrite())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Query(query, db)
+	if !errors.Is(err, ErrColumnMismatch) {
+		t.Fatal("expected ErrColumnMismatch, got", err)
+	}
+	if !strings.Contains(err.Error(), "expected 1 columns, got 3 [id, name, uuid]") {
+		t.Fatal("expected the error to list the columns, got", err)
+	}
+}
+
+func TestBulkInsertMaps(t *testing.T) {
+	db := mock(t)
+	result, err := BulkInsertMaps(context.Background(), db, "User", []string{"id", "name", "uuid"}, []map[string]any{
+		{"id": 2, "name": "Jane Doe", "uuid": "abc"},
+		{"id": 3, "name": "Billy Joel"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected != 2 {
+		t.Fatal("expected 2 rows affected, got", affected, err)
+	}
+	query, err := New[User](`SELECT User.id, User.uuid FROM User WHERE User.id > 1 ORDER BY User.id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := Query(query, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatal("expected 2 results, got", len(results))
+	}
+	if results[0].UUID.String != "abc" {
+		t.Fatal("expected uuid abc, got", results[0].UUID)
+	}
+	if results[1].UUID != nil {
+		t.Fatal("expected the missing uuid to be NULL, got", results[1].UUID)
+	}
+	recorder := &sqlRecorder{}
+	BulkInsertMaps(context.Background(), recorder, "User", []string{"id", "name"}, []map[string]any{{"id": 4}, {"id": 5}}, WithDialect(DialectPostgres))
+	if fmt.Sprint(recorder.sql) != "[INSERT INTO User (id, name) VALUES ($1, $2), ($3, $4)]" {
+		t.Fatal("unexpected sql", recorder.sql)
+	}
+}
+
+func TestSelectDistinct(t *testing.T) {
+	db := mock(t)
+	if _, err := db.Exec("INSERT INTO Account (id, userId) VALUES (3, 1)"); err != nil {
+		t.Fatal(err)
+	}
+	type Results struct {
+		User User
+	}
+	query, err := New[Results](`SELECT DISTINCT User.id, User.name FROM User JOIN Account ON Account.userId = User.id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, _, err := Explain(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql != "SELECT DISTINCT User.id, User.name FROM User JOIN Account ON Account.userId = User.id" {
+		t.Fatal("unexpected sql", sql)
+	}
+	results, err := Query(query, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatal("expected 1 result, got", len(results))
+	}
+	if results[0].User.Id != 1 || results[0].User.Name.String != "John Doe" {
+		t.Fatal("expected user 1, got", results[0].User)
+	}
+	sql, indices := Parse[Results](`SELECT DISTINCT ON (User.name) User.* FROM User ORDER BY User.name`)
+	if sql != "SELECT DISTINCT ON (User.name) User.id, User.name, User.uuid, User.createdAt FROM User ORDER BY User.name" {
+		t.Fatal("unexpected sql", sql)
+	}
+	if len(indices) != 4 {
+		t.Fatal("expected 4 indices, got", indices)
+	}
+}
+
+func TestRestField(t *testing.T) {
+	db := mock(t)
+	type Results struct {
+		Id    int            `tql:"id"`
+		Name  string         `tql:"name"`
+		Extra map[string]any `tql:",rest"`
+	}
+	query, err := New[Results](`SELECT User.id, User.name, User.id + 1 AS next FROM User`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, _, err := Explain(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql != "SELECT User.id, User.name, User.id + 1 AS next FROM User" {
+		t.Fatal("expected the projection to be kept with a rest field, got", sql)
+	}
+	results, err := Query(query, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatal("expected 1 result, got", len(results))
+	}
+	if results[0].Id != 1 || results[0].Name != "John Doe" {
+		t.Fatal("expected user 1, got", results[0])
+	}
+	if len(results[0].Extra) != 1 || fmt.Sprint(results[0].Extra["next"]) != "2" {
+		t.Fatal("expected the rest map to hold next, got", results[0].Extra)
+	}
+}
+
+func TestUnion(t *testing.T) {
+	db := mock(t)
+	type Results struct {
+		User User
+	}
+	query, err := New[Results](`SELECT User.id, User.name FROM User UNION ALL SELECT User.id, User.name FROM User ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, indices, err := Explain(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql != "SELECT User.id, User.name FROM User UNION ALL SELECT User.id, User.name FROM User ORDER BY id" || len(indices) != 2 {
+		t.Fatal("unexpected sql", sql, indices)
+	}
+	results, err := Query(query, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatal("expected 2 results, got", len(results))
+	}
+	if results[1].User.Name.String != "John Doe" {
+		t.Fatal("expected name John Doe, got", results[1].User.Name)
+	}
+	mismatched, err := New[Results](`SELECT User.id, User.name FROM User UNION SELECT User.id FROM User`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Explain(mismatched); !errors.Is(err, ErrUnsupportedUnion) {
+		t.Fatal("expected ErrUnsupportedUnion, got", err)
+	}
+}
+
+func TestGroupByAggregate(t *testing.T) {
+	db := mock(t)
+	type Results struct {
+		Name  string `tql:"name"`
+		Count int    `tql:"count"`
+	}
+	query, err := New[Results](`SELECT User.name, COUNT(Account.id) AS count FROM User JOIN Account ON Account.userId = User.id GROUP BY User.name HAVING COUNT(Account.id) > 0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, indices, err := Explain(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql != "SELECT name, COUNT(Account.id) AS count FROM User JOIN Account ON Account.userId = User.id GROUP BY User.name HAVING COUNT(Account.id) > 0" || len(indices) != 2 {
+		t.Fatal("expected the aggregate to be kept, got", sql, indices)
+	}
+	results, err := Query(query, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatal("expected 1 result, got", len(results))
+	}
+	if results[0].Name != "John Doe" || results[0].Count != 1 {
+		t.Fatal("expected John Doe with 1 account, got", results[0])
+	}
+}
+
+func TestNestedSelect(t *testing.T) {
+	db := mock(t)
+	type Results struct {
+		User    User
+		Account Account
+	}
+	type Query struct {
+		Account Account
+		User    User
+	}
+	query, err := New[Results](`SELECT User.*, Account.id FROM Account INNER JOIN (SELECT User.id,  User.createdAt FROM User where User.id = ?) AS User ON User.id = Account.userId`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stmt, err := Prepare(query, db, Params{"User": Params{"Id": 1}, "Account": Account{Id: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := stmt.Query(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Info("results", "results", results)
+}
+
+func TestParamPreventsInjection(t *testing.T) {
+	db := mock(t)
+	var numUsersBefore int
+	if err := db.QueryRow("SELECT COUNT(*) FROM User").Scan(&numUsersBefore); err != nil {
+		t.Fatal(err)
+	}
+	if numUsersBefore == 0 {
+		t.Fatal("need users in the database to compare against, got no users")
+	}
+	type Results struct {
+		User User `tql:"omit=createdAt"`
+	}
+	query, err := New[User](`SELECT uuid, name FROM User WHERE User.name = {{ param .name }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "John Doe"
+	badInputs := []string{
+		name,
+		name + "'; DROP TABLE User; --",
+	}
+	for _, badInput := range badInputs {
+		queryStmt, err := Prepare(query, db, Params{"name": badInput})
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := queryStmt.Query()
+		slog.Info("results", "results", results)
+	}
+	var numUsersAfter int
+	if err := db.QueryRow("SELECT COUNT(*) FROM User").Scan(&numUsersAfter); err != nil {
+		t.Fatal(err)
+	}
+	if numUsersBefore != numUsersAfter {
+		t.Fatalf("expected %d users, got %d", numUsersBefore, numUsersAfter)
+	}
+}
+
+func TestNestedSelectWithAlias(t *testing.T) {
+	db := mock(t)
+	type Results struct {
+		User struct {
+			UserId int `tql:"userId"`
+		}
+		Account Account
+	}
+	type Query struct {
+		Account Account
+		User    User
+	}
+	query, err := New[Results](`SELECT User.*, Account.id FROM Account INNER JOIN (SELECT User.id as userId,  User.createdAt FROM User where User.id = ?) AS User ON User.userId = Account.userId`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stmt, err := Prepare(query, db, Params{"User": Params{"Id": 1}, "Account": Account{Id: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := stmt.Query(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Info("results", "results", results)
+}
+func TestWithTemplate(t *testing.T) {
+	db := mock(t)
+	type Results struct {
+		User User `tql:"omit=createdAt"`
+	}
+	query, err := New[User](`SELECT uuid, name FROM User WHERE User.name = '{{ .name }}'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queryStmt, err := Prepare(query, db, Params{"name": "John Doe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := queryStmt.Query()
+	slog.Info("results", "results", results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatal("expected 1 result, got", len(results))
+	}
+}
+
+func TestWithConditionalTable(t *testing.T) {
+	db := mock(t)
+	type Results struct {
+		User    User
+		Account Account
+	}
+	query, err := New[Results](`SELECT {{ .Table }}.id FROM {{ .Table }} WHERE {{ .Table }}.id = ?`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := Prepare(query, db, Params{"Table": "User"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := stmt.Query(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slog.Info("results", "results", results)
+}
+
+func TestWithNilQuery(t *testing.T) {
+	db := mock(t)
+	var nilQuery *QueryTemplate[any]
+	if _, err := Prepare(nilQuery, db, Params{"createdAt": time.Now().Format("2006-01-02 15:04:05")}); !errors.Is(err, ErrPreparingQuery) {
+		t.Fatal(err)
+	}
+	if _, err := Query(nilQuery, db); !errors.Is(err, ErrExecutingQuery) {
+		t.Fatal(err)
+	}
+}
+
+func TestWithNilTemplate(t *testing.T) {
+	db := mock(t)
+	queryWithNilTemplate := &QueryTemplate[any]{}
+	if _, err := Prepare(queryWithNilTemplate, db); !errors.Is(err, ErrNilTemplate) {
+		t.Fatal(err)
+	}
+}
+
+func TestRegisterDefaultFuncs(t *testing.T) {
+	if err := RegisterDefaultFuncs(Functions{"tenant": func() string { return "acme" }, "table": func() string { return "User" }}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		defaultFunctionsMu.Lock()
+		delete(defaultFunctions, "tenant")
+		delete(defaultFunctions, "table")
+		defaultFunctionsMu.Unlock()
+	}()
+	query, err := New[User](`SELECT User.id FROM {{ table }} WHERE User.name = '{{ tenant }}'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, _, err := query.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql != "SELECT User.id FROM User WHERE User.name = 'acme'" {
+		t.Fatal("expected the registered functions to be used, got", sql)
+	}
+	// functions passed to New take precedence over the registered ones
+	query, err = New[User](`SELECT User.id FROM User WHERE User.name = '{{ tenant }}'`, Functions{"tenant": func() string { return "other" }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql, _, _ := query.Generate(); sql != "SELECT User.id FROM User WHERE User.name = 'other'" {
+		t.Fatal("expected the function passed to New to be used, got", sql)
+	}
+	// the functions bound by every query generation can't be registered
+	for _, name := range []string{"param", "ident"} {
+		if err := RegisterDefaultFuncs(Functions{name: func(value any) string { return "'unbound'" }, "other": func() string { return "" }}); !errors.Is(err, ErrInvalidArgument) {
+			t.Fatal("expected ErrInvalidArgument for", name, "got", err)
+		}
+	}
+	defaultFunctionsMu.RLock()
+	_, registered := defaultFunctions["other"]
+	defaultFunctionsMu.RUnlock()
+	if registered {
+		t.Fatal("expected no function to be registered with a reserved one")
+	}
+	query, err = New[User](`SELECT User.id FROM User WHERE User.name = {{ ident "name" }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql, _, _ := query.Generate(); sql != "SELECT User.id FROM User WHERE User.name = `name`" {
+		t.Fatal("expected the built-in ident function to be used, got", sql)
+	}
+}
+
+func TestWithFunctions(t *testing.T) {
+	db := mock(t)
+	type Results struct {
+		User User `tql:"user;omit=createdAt"`
+	}
+	query, err := New[Results](`INSERT INTO User (name, id, uuid) VALUES (?, ?, '{{ uuid }}')`, Functions{"uuid": func() string { return "123" }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Prepare(query, db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Exec(query, db, "Billy Joel", 2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewWithOptions(t *testing.T) {
+	query, err := NewWithOptions[User](`SELECT User.id FROM User WHERE User.id = {{ param .Id }} AND User.uuid = '{{ uuid }}'`,
+		WithFunctions(Functions{"uuid": func() string { return "123" }}), WithDialect(DialectPostgres))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql, _, _ := query.Generate(Params{"Id": 1}); sql != "SELECT User.id FROM User WHERE User.id = $1 AND User.uuid = '123'" {
+		t.Fatal("expected the functions and the dialect to be applied, got", sql)
+	}
+	// functions passed to New are merged in order like WithFunctions
+	query = Must[User](`SELECT User.id FROM User WHERE User.uuid = '{{ uuid }}{{ tenant }}'`,
+		Functions{"uuid": func() string { return "1" }, "tenant": func() string { return "a" }}, Functions{"uuid": func() string { return "2" }})
+	if sql, _, _ := query.Generate(); sql != "SELECT User.id FROM User WHERE User.uuid = '2a'" {
+		t.Fatal("expected the functions to be merged, got", sql)
+	}
+}
+
+func TestFunctionsKeepBuiltins(t *testing.T) {
+	db := mock(t)
+	query, err := New[User](`INSERT INTO User (id, name, uuid) VALUES ({{ param .Id }}, {{ param .Name }}, '{{ uuid }}')`, Functions{"uuid": func() string { return "123" }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, args, err := GenerateAndArgs(query, Params{"Id": 2, "Name": "Jane Doe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql != "INSERT INTO User (id, name, uuid) VALUES (?, ?, '123')" || fmt.Sprint(args) != "[2 Jane Doe]" {
+		t.Fatal("expected the params to be bound next to the custom function, got", sql, args)
+	}
+	stmt, err := Prepare(query, db, Params{"Id": 2, "Name": "Jane Doe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	if _, err := stmt.Exec(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestComplex(t *testing.T) {
+	db := mock(t)
+	type Results struct {
+		User User `tql:"omit=createdAt"`
+	}
+	// templates are only rendered during the prepare to prevent SQL injections use
+	query, err := New[Results](`SELECT {{ .Select }} FROM User {{ if .Where}} WHERE {{ .Where }} {{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryStmt, err := Prepare(query, db, Params{"Select": "User.id, User.name", "Where": "User.id = 1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := queryStmt.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatal("expected 1 result, got", len(results))
+	}
+	if results[0].User.Id != 1 {
+		slog.Info("results", "results", results)
+		t.Fatal("expected id 1, got", results[0].User.Id)
+	}
+}
+
+func TestSelectAll(t *testing.T) {
+	db := mock(t)
+	type Results struct {
+		User User
+	}
+	query, err := New[Results](`SELECT * FROM User`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryStmt, err := Prepare(query, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := queryStmt.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatal("expected 1 result, got", len(results))
+	}
+	if results[0].User.Id != 1 {
+		t.Fatal("expected id 1, got", results[0].User.Id)
+	}
+}
+
+func TestTopLevelSelectAll(t *testing.T) {
+	db := mock(t)
+	query, err := New[User](`SELECT * FROM User`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryStmt, err := Prepare(query, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := queryStmt.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatal("expected 1 result, got", len(results))
+	}
+	if results[0].Id != 1 {
+		t.Fatal("expected id 1, got", results[0].Id)
+	}
+}
+
+func TestSelectAllFromTable(t *testing.T) {
+	db := mock(t)
+	type Results struct {
+		User    User
+		Account Account
+	}
+	query, err := New[Results](`SELECT User.*, Account.id FROM User LEFT JOIN Account ON User.id = Account.userId`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := Query(query, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatal("expected 1 result, got", len(results))
+	}
+	if results[0].User.Id != 1 {
+		t.Fatal("expected id 1, got", results[0].User.Id)
+	}
+	if results[0].Account.Id != 2 {
+		t.Fatal("expected id 2, got", results[0].Account.Id)
+	}
+}
+
+func TestSelectAllFromTablWithOmit(t *testing.T) {
+	db := mock(t)
+	type Results struct {
+		User    User `tql:"omit=createdAt"`
+		Account Account
+	}
+	query, err := New[Results](`SELECT User.*, Account.id FROM User LEFT JOIN Account ON User.id = Account.userId`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := Query(query, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatal("expected 1 result, got", len(results))
+	}
+	if results[0].User.Id != 1 {
+		t.Fatal("expected id 1, got", results[0].User.Id)
+	}
+	if results[0].Account.Id != 2 {
+		t.Fatal("expected id 2, got", results[0].Account.Id)
+	}
+}
+
+func TestWithTransaction(t *testing.T) {
+	db := mock(t)
+	tx, err := db.Begin()
+	defer tx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	type Results struct {
+		User User
+	}
+	query, err := New[Results](`SELECT User.id, User.name, User.createdAt FROM User where User.id = ?`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := Query(query, tx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatal("expected 1 result, got", len(results))
+	}
+	if results[0].User.Id != 1 {
+		t.Fatal("expected id 1, got", results[0].User.Id)
+	}
+
+}
+
+func TestRawIdent(t *testing.T) {
+	query, err := New[User](`SELECT User.id FROM {{ raw .Table }} WHERE {{ raw .Column }} = {{ param .Id }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, params, err := query.Generate(Params{"Table": MustIdent("User"), "Column": MustIdent("User.id"), "Id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql != "SELECT User.id FROM User WHERE User.id = ?" {
+		t.Fatal("unexpected sql", sql)
+	}
+	if len(params) != 1 {
+		t.Fatal("expected 1 param, got", len(params))
+	}
+}
+
+func TestRawRejectsInvalidIdent(t *testing.T) {
+	for _, name := range []string{"User; DROP TABLE User", "User name", "", "1User"} {
+		if _, err := Ident(name); !errors.Is(err, ErrInvalidIdentifier) {
+			t.Fatalf("expected ErrInvalidIdentifier for %q, got %v", name, err)
+		}
+	}
+	query, err := New[User](`SELECT User.id FROM {{ raw .Table }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := query.Generate(Params{"Table": "User; DROP TABLE User"}); !errors.Is(err, ErrInvalidIdentifier) {
+		t.Fatal("expected ErrInvalidIdentifier, got", err)
+	}
+}
+
+func TestDbTagFallback(t *testing.T) {
+	type Results struct {
+		Id        int        `db:"id"`
+		CreatedAt *time.Time `db:"created_at,omitempty"`
+		Name      string     `tql:"name" db:"full_name"`
+		UUID      string     `tql:"omit=true" db:"uuid"`
+	}
+	sql, indices := Parse[Results](`SELECT User.id, User.created_at, User.name, User.uuid FROM User`)
+	if sql != "SELECT id, created_at, name FROM User" {
+		t.Fatal("unexpected sql", sql)
+	}
+	if len(indices) != 3 {
+		t.Fatal("expected 3 indices, got", indices)
+	}
+	_, indices = Parse[Results](`SELECT User.id, User.created_at, User.name FROM User`, WithTagNames("tql"))
+	if len(indices) != 1 {
+		t.Fatal("expected only the tql tagged field, got", indices)
+	}
+}
+
+func TestBulkInsertBatches(t *testing.T) {
+	type Row struct {
+		Id   int    `tql:"id"`
+		Name string `tql:"name"`
+		UUID string `tql:"omit=true"`
+	}
+	rows := []Row{{Id: 1, Name: "a"}, {Id: 2, Name: "b"}, {Id: 3, Name: "c"}, {Id: 4, Name: "d"}, {Id: 5, Name: "e"}}
+	batches, err := BulkInsertBatches("User", rows, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 3 {
+		t.Fatal("expected 3 batches, got", len(batches))
+	}
+	if batches[0].SQL != "INSERT INTO User (id, name) VALUES (?, ?), (?, ?)" {
+		t.Fatal("unexpected sql", batches[0].SQL)
+	}
+	if batches[2].SQL != "INSERT INTO User (id, name) VALUES (?, ?)" {
+		t.Fatal("unexpected sql", batches[2].SQL)
+	}
+	if fmt.Sprint(batches[1].Args) != "[3 c 4 d]" {
+		t.Fatal("unexpected args", batches[1].Args)
+	}
+	if fmt.Sprint(batches[2].Args) != "[5 e]" {
+		t.Fatal("unexpected args", batches[2].Args)
+	}
+	if _, err := BulkInsertBatches("User; DROP TABLE User", rows, 2); !errors.Is(err, ErrInvalidIdentifier) {
+		t.Fatal("expected ErrInvalidIdentifier, got", err)
+	}
+	type TaggedRow struct {
+		Id   int    `db:"id"`
+		Name string `db:"name" tql:"label"`
+	}
+	batches, err = BulkInsertBatches("User", []TaggedRow{{1, "a"}, {2, "b"}, {3, "c"}}, 2, WithDialect(DialectPostgres), WithTagNames("db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batches[0].SQL != "INSERT INTO User (id, name) VALUES ($1, $2), ($3, $4)" || batches[1].SQL != "INSERT INTO User (id, name) VALUES ($1, $2)" {
+		t.Fatal("unexpected sql", batches[0].SQL, batches[1].SQL)
+	}
+}
+
+func TestInsertInto(t *testing.T) {
+	db := mock(t)
+	type Row struct {
+		Id        int        `tql:"id"`
+		Name      string     `tql:"name"`
+		UUID      string     `tql:"uuid"`
+		CreatedAt *time.Time `tql:"createdAt;omit=true"`
+	}
+	query, err := InsertInto[Row]("User")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := Prepare(query, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	if stmt.SQL != "INSERT INTO User (id, name, uuid) VALUES (?, ?, ?)" {
+		t.Fatal("unexpected sql", stmt.SQL)
+	}
+	if _, err := ExecStruct(stmt, Row{Id: 2, Name: "Jane Doe", UUID: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	name, err := QueryScalar[string](db, "SELECT name FROM User WHERE uuid = ?", "abc")
+	if err != nil || name != "Jane Doe" {
+		t.Fatal("expected the inserted row, got", name, err)
+	}
+	postgres, err := InsertInto[Row]("User", WithDialect(DialectPostgres))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql, _, _ := postgres.Generate(); sql != "INSERT INTO User (id, name, uuid) VALUES ($1, $2, $3)" {
+		t.Fatal("unexpected sql", sql)
+	}
+	if _, err := InsertInto[Row]("User; DROP TABLE User"); !errors.Is(err, ErrInvalidIdentifier) {
+		t.Fatal("expected ErrInvalidIdentifier, got", err)
+	}
+}
+
+func TestInsertIntoEmbeddedAndRest(t *testing.T) {
+	db := mock(t)
+	type Base struct {
+		Id int `tql:"id"`
+	}
+	type Row struct {
+		Base
+		Name  string         `tql:"name"`
+		UUID  string         `tql:"uuid"`
+		Extra map[string]any `tql:",rest"`
+	}
+	query, err := InsertInto[Row]("User")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := Prepare(query, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	if stmt.SQL != "INSERT INTO User (id, name, uuid) VALUES (?, ?, ?)" {
+		t.Fatal("unexpected sql", stmt.SQL)
+	}
+	if _, err := ExecStruct(stmt, Row{Base: Base{Id: 3}, Name: "Jane Doe", UUID: "def"}); err != nil {
+		t.Fatal(err)
+	}
+	id, err := QueryScalar[int](db, "SELECT id FROM User WHERE uuid = ?", "def")
+	if err != nil || id != 3 {
+		t.Fatal("expected the inserted row, got", id, err)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	type Results struct {
+		User    User
+		Account Account
+	}
+	query, err := New[Results](`SELECT User.id, Account.id FROM User JOIN Account ON User.id = Account.userId WHERE User.id = {{ param .Id }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, indices, err := Explain(query, Params{"Id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql != "SELECT User.id, Account.id FROM User JOIN Account ON User.id = Account.userId WHERE User.id = ?" {
+		t.Fatal("unexpected sql", sql)
+	}
+	if fmt.Sprint(indices) != "[[0 0] [1 0]]" {
+		t.Fatal("unexpected indices", indices)
+	}
+	if _, _, err := Explain[Results](nil); !errors.Is(err, ErrNilQuery) {
+		t.Fatal("expected ErrNilQuery, got", err)
+	}
+}
+
+func TestWithNoRewrite(t *testing.T) {
+	type Results struct {
+		User    User
+		Account Account
+	}
+	original := `SELECT User.id,  CAST(User.name AS CHAR(10)) as User.name,Account.id FROM User JOIN Account ON User.id = Account.userId`
+	rewritten, rewrittenIndices := Parse[Results](original)
+	sql, indices := Parse[Results](original, WithNoRewrite())
+	if sql != original {
+		t.Fatal("expected the sql to be unchanged, got", sql)
+	}
+	if rewritten == original {
+		t.Fatal("expected the sql to be rewritten without the option")
+	}
+	if fmt.Sprint(indices) != fmt.Sprint(rewrittenIndices) || fmt.Sprint(indices) != "[[0 0] [0 1] [1 0]]" {
+		t.Fatal("unexpected indices", indices)
+	}
+}
+
+func TestQueryGrouped(t *testing.T) {
+	db := mock(t)
+	if _, err := db.Exec("INSERT INTO Account (id, userId) VALUES (3, 1)"); err != nil {
+		t.Fatal(err)
+	}
+	type Row struct {
+		User    User
+		Account Account
+	}
+	type UserAccounts struct {
+		User
+		Accounts []Account
+	}
+	query, err := New[Row](`SELECT User.id, User.name, Account.id FROM User JOIN Account ON User.id = Account.userId ORDER BY User.id, Account.id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := QueryGrouped(query, db, Grouping[Row, UserAccounts, int]{
+		Key:    func(row Row) int { return row.User.Id },
+		Parent: func(row Row) UserAccounts { return UserAccounts{User: row.User} },
+		Append: func(user *UserAccounts, row Row) { user.Accounts = append(user.Accounts, row.Account) },
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
 	if len(results) != 1 {
 		t.Fatal("expected 1 result, got", len(results))
 	}
-	if results[0].User.Id != 1 {
-		t.Fatal("expected id 1, got", results[0].User.Id)
+	if results[0].Id != 1 {
+		t.Fatal("expected id 1, got", results[0].Id)
+	}
+	if len(results[0].Accounts) != 2 || results[0].Accounts[0].Id != 2 || results[0].Accounts[1].Id != 3 {
+		t.Fatal("expected accounts 2 and 3, got", results[0].Accounts)
+	}
+}
+
+func TestParamOrderFollowsPlaceholders(t *testing.T) {
+	query, err := New[User](`SELECT User.id FROM User WHERE User.uuid = {{ param .Zeta }} AND User.id IN {{ param .Alpha }} AND User.name = {{ param .Mid }} AND User.id > {{ param .Beta }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// run a few times since map iteration order is randomized
+	for i := 0; i < 20; i++ {
+		params := Params{}
+		params["Mid"] = "John Doe"
+		params["Zeta"] = "uuid"
+		params["Beta"] = 0
+		params["Alpha"] = []int{1, 2}
+		_, args, err := query.Generate(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(args) != "[uuid 1 2 John Doe 0]" {
+			t.Fatal("expected args in placeholder order, got", args)
+		}
+	}
+}
+
+// staleDriver is a fake driver whose first prepared statement fails with a stale statement error
+type staleDriver struct {
+	prepared int
+}
+
+type staleConn struct {
+	driver *staleDriver
+}
+
+type staleStmt struct {
+	stale bool
+}
+
+type staleRows struct {
+	done bool
+}
+
+func (d *staleDriver) Open(name string) (driver.Conn, error) {
+	return &staleConn{driver: d}, nil
+}
+
+func (c *staleConn) Prepare(query string) (driver.Stmt, error) {
+	c.driver.prepared++
+	return &staleStmt{stale: c.driver.prepared == 1}, nil
+}
+
+func (c *staleConn) Close() error {
+	return nil
+}
+
+func (c *staleConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+func (s *staleStmt) Close() error {
+	return nil
+}
+
+func (s *staleStmt) NumInput() int {
+	return -1
+}
+
+func (s *staleStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if s.stale {
+		return nil, errors.New("Error 1243 (HY000): Unknown prepared statement handler (1) given to mysqld_stmt_execute")
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *staleStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if s.stale {
+		return nil, errors.New("Error 1243 (HY000): Unknown prepared statement handler (1) given to mysqld_stmt_execute")
+	}
+	return &staleRows{}, nil
+}
+
+func (r *staleRows) Columns() []string {
+	return []string{"id"}
+}
+
+func (r *staleRows) Close() error {
+	return nil
+}
+
+func (r *staleRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(1)
+	return nil
+}
+
+func TestWithStmtRecovery(t *testing.T) {
+	staleDb := &staleDriver{}
+	db := sql.OpenDB(driverConnector{staleDb})
+	defer db.Close()
+	type Results struct {
+		Id int `tql:"id"`
+	}
+	query, err := NewWithOptions[Results](`SELECT User.id FROM User`, WithStmtRecovery())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := Prepare(query, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := stmt.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Id != 1 {
+		t.Fatal("expected id 1, got", results)
+	}
+	if staleDb.prepared != 2 {
+		t.Fatal("expected the statement to be prepared twice, got", staleDb.prepared)
+	}
+	withoutRecovery, err := New[Results](`SELECT User.id FROM User`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	staleDb.prepared = 0
+	stmt, err = Prepare(withoutRecovery, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stmt.Exec(); err == nil {
+		t.Fatal("expected the stale statement error without recovery")
+	}
+}
+
+// driverConnector opens connections from a driver without registering it
+type driverConnector struct {
+	driver driver.Driver
+}
+
+func (c driverConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open("")
+}
+
+func (c driverConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// routeKey is the context key of the routing hint recorded by contextDriver
+type routeKey struct{}
+
+// contextDriver records the routing hint of the contexts its connections are called with
+type contextDriver struct {
+	routes []any
+}
+
+type contextConn struct {
+	driver *contextDriver
+}
+
+type contextStmt struct {
+	driver *contextDriver
+}
+
+func (d *contextDriver) Open(name string) (driver.Conn, error) {
+	return &contextConn{driver: d}, nil
+}
+
+func (c *contextConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *contextConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	c.driver.routes = append(c.driver.routes, ctx.Value(routeKey{}))
+	return &contextStmt{driver: c.driver}, nil
+}
+
+func (c *contextConn) Close() error {
+	return nil
+}
+
+func (c *contextConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+func (s *contextStmt) Close() error {
+	return nil
+}
+
+func (s *contextStmt) NumInput() int {
+	return -1
+}
+
+func (s *contextStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("ExecContext is expected")
+}
+
+func (s *contextStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("QueryContext is expected")
+}
+
+func (s *contextStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	s.driver.routes = append(s.driver.routes, ctx.Value(routeKey{}))
+	return driver.RowsAffected(1), nil
+}
+
+func (s *contextStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	s.driver.routes = append(s.driver.routes, ctx.Value(routeKey{}))
+	return &staleRows{}, nil
+}
+
+func TestContextValues(t *testing.T) {
+	contextDb := &contextDriver{}
+	db := sql.OpenDB(driverConnector{contextDb})
+	defer db.Close()
+	type Results struct {
+		Id int `tql:"id"`
+	}
+	query, err := NewWithOptions[Results](`SELECT User.id FROM User`, WithTracer(recordingTracer{spans: &[]*recordingSpan{}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), routeKey{}, "replica")
+	if _, err := QueryContext(query, ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ExecContext(query, ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(contextDb.routes) != "[replica replica replica replica]" {
+		t.Fatal("expected the context to reach the driver, got", contextDb.routes)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	type Results struct {
+		User    User `tql:"omit=uuid"`
+		Account Account
+	}
+	valid, err := New[Results](`SELECT User.id, User.name, User.createdAt, a.id FROM User JOIN Account a ON a.userId = User.id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	typo, err := New[Results](`SELECT User.id, User.nmae, User.createdAt, Account.id, COUNT(Account.id) FROM User JOIN Account ON Account.userId = User.id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = typo.Validate()
+	if !errors.Is(err, ErrUnmatchedColumns) {
+		t.Fatal("expected ErrUnmatchedColumns, got", err)
+	}
+	if !strings.Contains(err.Error(), "unmatched fields [User.name], unmatched columns [User.nmae, COUNT(Account.id)]") {
+		t.Fatal("expected the error to list the unmatched names, got", err)
+	}
+	if _, err := NewWithOptions[Results](typo.source, WithStrictColumns()); !errors.Is(err, ErrUnmatchedColumns) {
+		t.Fatal("expected ErrUnmatchedColumns, got", err)
+	}
+	dynamic, err := New[Results](`SELECT {{ .Columns }} FROM User`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dynamic.Validate(); err != nil {
+		t.Fatal("expected dynamic projections to be skipped, got", err)
+	}
+}
+
+func TestGenerateAndArgs(t *testing.T) {
+	query, err := New[User](`SELECT User.id, User.name FROM User WHERE User.id IN {{ param .Ids }} AND User.name = {{ param .Name }}`)
+	if err != nil {
+		t.Fatal(err)
 	}
+	sql, args, err := GenerateAndArgs(query, Params{"Name": "John Doe", "Ids": []int{1, 2, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql != "SELECT id, name FROM User WHERE User.id IN (?,?,?) AND User.name = ?" {
+		t.Fatal("unexpected sql", sql)
+	}
+	if fmt.Sprint(args) != "[1 2 3 John Doe]" {
+		t.Fatal("expected args in placeholder order, got", args)
+	}
+}
+
+type countingPreparer struct {
+	*sql.DB
+	prepared int
+}
 
+func (c *countingPreparer) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	c.prepared++
+	return c.DB.PrepareContext(ctx, query)
+}
+
+func TestWithPreparer(t *testing.T) {
+	db := &countingPreparer{DB: mock(t)}
+	query, err := New[User](`SELECT User.id, User.name FROM User where User.id = ?`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := Query(query, db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatal("expected 1 result, got", len(results))
+	}
+	if db.prepared != 1 {
+		t.Fatal("expected the wrapper to prepare 1 statement, got", db.prepared)
+	}
 }
 
 func BenchmarkTQLCreation(b *testing.B) {
@@ -809,6 +3446,52 @@ func BenchmarkTQLCreation(b *testing.B) {
 	}
 }
 
+func BenchmarkExplainWideStruct(b *testing.B) {
+	type Wide struct {
+		Column0  string `tql:"column0"`
+		Column1  string `tql:"column1"`
+		Column2  string `tql:"column2"`
+		Column3  string `tql:"column3"`
+		Column4  string `tql:"column4"`
+		Column5  string `tql:"column5"`
+		Column6  string `tql:"column6"`
+		Column7  string `tql:"column7"`
+		Column8  string `tql:"column8"`
+		Column9  string `tql:"column9"`
+		Column10 string `tql:"column10"`
+		Column11 string `tql:"column11"`
+		Column12 string `tql:"column12"`
+		Column13 string `tql:"column13"`
+		Column14 string `tql:"column14"`
+		Column15 string `tql:"column15"`
+		Column16 string `tql:"column16"`
+		Column17 string `tql:"column17"`
+		Column18 string `tql:"column18"`
+		Column19 string `tql:"column19"`
+	}
+	type Results struct {
+		Wide    Wide
+		Account Account
+	}
+	for _, bench := range []struct {
+		name string
+		sql  string
+	}{
+		{"Columns", `SELECT Wide.column0, Wide.column1, Wide.column2, Wide.column3, Wide.column4, Wide.column5, Wide.column6, Wide.column7, Wide.column8, Wide.column9, Wide.column10, Wide.column11, Wide.column12, Wide.column13, Wide.column14, Wide.column15, Wide.column16, Wide.column17, Wide.column18, Wide.column19, Account.id FROM Wide JOIN Account ON Account.userId = Wide.column0`},
+		{"SelectAll", `SELECT * FROM Wide JOIN Account ON Account.userId = Wide.column0`},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			query := Must[Results](bench.sql)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := Explain(query); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 func BenchmarkUnprepared(b *testing.B) {
 	db := mock(b)
 	type Results struct {
@@ -882,3 +3565,112 @@ func BenchmarkPrepared(b *testing.B) {
 		}
 	})
 }
+
+func BenchmarkExecBatch(b *testing.B) {
+	db := mock(b)
+	defer db.Close()
+	query := Must[User](`UPDATE User SET name = ? WHERE id = ?`)
+	paramSets := make([][]any, 100)
+	for i := range paramSets {
+		paramSets[i] = []any{fmt.Sprint("John Doe ", i), 1}
+	}
+	b.Run("Exec", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, params := range paramSets {
+				if _, err := Exec(query, db, params...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("ExecBatch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			stmt, err := Prepare(query, db)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := stmt.ExecBatch(paramSets); err != nil {
+				b.Fatal(err)
+			}
+			stmt.Close()
+		}
+	})
+}
+
+func BenchmarkPreparedParallel(b *testing.B) {
+	db := mock(b)
+	defer db.Close()
+	prepared, err := Prepare(Must[User](`SELECT User.id, User.name, User.createdAt FROM User WHERE User.id = ?`), db)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer prepared.Close()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			users, err := prepared.Query(1)
+			if err != nil || len(users) != 1 {
+				b.Fatal("expected 1 user, got", len(users), err)
+			}
+		}
+	})
+}
+
+func BenchmarkScanRows(b *testing.B) {
+	db := mock(b)
+	defer db.Close()
+	rows := make([]User, 10000)
+	for i := range rows {
+		rows[i] = User{Id: i + 2, Name: &sql.NullString{String: fmt.Sprint("John Doe ", i), Valid: true}}
+	}
+	batches, err := BulkInsertBatches("User", rows, 1000)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, batch := range batches {
+		if _, err := db.Exec(batch.SQL, batch.Args...); err != nil {
+			b.Fatal(err)
+		}
+	}
+	prepared, err := Prepare(Must[User](`SELECT User.id, User.name, User.createdAt FROM User`), db)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer prepared.Close()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := prepared.Query(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseCache(b *testing.B) {
+	db := mock(b)
+	defer db.Close()
+	type Results struct {
+		User User
+	}
+	for _, bench := range []struct {
+		name    string
+		options []Option
+	}{{"Uncached", nil}, {"Cached", []Option{WithParseCache()}}} {
+		b.Run(bench.name, func(b *testing.B) {
+			ClearParseCache()
+			queries := []*QueryTemplate[Results]{
+				MustWithOptions[Results](`SELECT User.id, User.name, User.createdAt FROM User WHERE User.id = ?`, bench.options...),
+				MustWithOptions[Results](`SELECT User.id, User.name, User.createdAt FROM User WHERE User.id = ?`, bench.options...),
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				stmt, err := Prepare(queries[i%2], db)
+				if err != nil {
+					b.Fatal(err)
+				}
+				stmt.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package tql
+
+import (
+	"context"
+)
+
+// Tracer starts the spans around the preparation and execution of the queries, see WithTracer.
+// The oteltql package implements it with an OpenTelemetry tracer.
+type Tracer interface {
+	// Start starts a span named after the traced operation
+	//
+	// Parameters:
+	//   - ctx: The parent context
+	//   - name: The name of the span, e.g. tql.query
+	//
+	// Returns:
+	//   - context.Context: The context holding the span, passed to the traced operation
+	//   - Span: The started span
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer
+type Span interface {
+	// SetSQL records the SQL with its placeholders, the bound values are never passed
+	//
+	// Parameters:
+	//   - sql: The SQL with placeholders
+	SetSQL(sql string)
+
+	// End ends the span, marking it as errored if there is an error
+	//
+	// Parameters:
+	//   - err: The error of the traced operation, nil if it succeeded
+	End(err error)
+}
+
+// noopSpan is the span of the queries without a tracer
+type noopSpan struct{}
+
+func (noopSpan) SetSQL(string) {}
+
+func (noopSpan) End(error) {}
+
+// startSpan starts a span with the tracer of the options, the span is a no-op span when tracing is disabled
+//
+// Parameters:
+//   - ctx: The parent context
+//   - opts: The options of the query
+//   - name: The name of the span
+//
+// Returns:
+//   - context.Context: The context holding the span
+//   - Span: The started span
+func startSpan(ctx context.Context, opts options, name string) (context.Context, Span) {
+	if opts.tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return opts.tracer.Start(ctx, name)
+}
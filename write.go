@@ -0,0 +1,469 @@
+package tql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Batch is a generated statement with the arguments to pass to sql.Exec
+type Batch struct {
+	SQL  string
+	Args []any
+}
+
+// BulkInsertBatches generates multi-row INSERT statements for the rows, chunkSize rows per statement.
+// The columns are the tagged fields of T that are not omitted, see New for more details on the struct tags.
+// The statements are not executed so callers can run them in their own transaction or across goroutines.
+//
+// Example usage:
+//
+//	batches, err := BulkInsertBatches("User", users, 1000)
+//	for _, batch := range batches {
+//	    _, err := tx.Exec(batch.SQL, batch.Args...)
+//	}
+//
+// Parameters:
+//   - table: The table to insert into, must be a valid identifier
+//   - rows: The rows to insert
+//   - chunkSize: The maximum number of rows per statement
+//   - maybeOptions: Optional options such as WithDialect or WithTagNames
+//
+// Returns:
+//   - []Batch: The statements and their arguments, the last batch holds the remaining rows
+//   - error: If T is not a struct, the table or a column is not a valid identifier or chunkSize is not positive
+func BulkInsertBatches[T any](table string, rows []T, chunkSize int, maybeOptions ...Option) ([]Batch, error) {
+	if chunkSize <= 0 {
+		log.Error("chunk size must be positive", "chunkSize", chunkSize)
+		return nil, errors.Join(ErrPreparingQuery, errors.New("chunk size must be positive"))
+	}
+	if _, err := Ident(table); err != nil {
+		return nil, errors.Join(ErrPreparingQuery, err)
+	}
+	opts := newOptions(maybeOptions...)
+	columns, indices, err := structColumns(reflect.TypeFor[T](), opts.tagNames)
+	if err != nil {
+		return nil, errors.Join(ErrPreparingQuery, err)
+	}
+	prefix := "INSERT INTO " + table + " (" + strings.Join(columns, ", ") + ") VALUES "
+	batches := make([]Batch, 0, (len(rows)+chunkSize-1)/chunkSize)
+	for start := 0; start < len(rows); start += chunkSize {
+		chunk := rows[start:min(start+chunkSize, len(rows))]
+		values := make([]string, len(chunk))
+		args := make([]any, 0, len(chunk)*len(columns))
+		for i, row := range chunk {
+			placeholders := make([]string, len(indices))
+			rowValue := reflect.ValueOf(row)
+			for j, index := range indices {
+				// the ordinals start over in every batch since each batch is its own statement
+				placeholders[j] = placeholder(opts.dialect, len(args)+1)
+				args = append(args, rowValue.FieldByIndex(index).Interface())
+			}
+			values[i] = "(" + strings.Join(placeholders, ", ") + ")"
+		}
+		batches = append(batches, Batch{SQL: prefix + strings.Join(values, ", "), Args: args})
+	}
+	return batches, nil
+}
+
+// InsertInto creates a query inserting a single row of T into the table. The columns are the tagged fields of T that
+// are not omitted, so an auto-generated column such as createdAt is left to the database by omitting its field.
+// The values are bound from a T with ExecStruct, in the order of the columns.
+//
+// Example usage:
+//
+//	query, err := InsertInto[User]("User")
+//	stmt, err := Prepare(query, db)
+//	result, err := ExecStruct(stmt, user)
+//
+// Parameters:
+//   - table: The table to insert into, must be a valid identifier
+//   - maybeOptions: Optional options such as WithDialect or WithTagNames
+//
+// Returns:
+//   - *QueryTemplate[T]: The query inserting a row of T
+//   - error: If T is not a struct, has no columns or the table or a column is not a valid identifier
+func InsertInto[T any](table string, maybeOptions ...Option) (*QueryTemplate[T], error) {
+	if _, err := Ident(table); err != nil {
+		return nil, errors.Join(ErrPreparingQuery, err)
+	}
+	opts := newOptions(maybeOptions...)
+	columns, _, err := structColumns(reflect.TypeFor[T](), opts.tagNames)
+	if err != nil {
+		return nil, errors.Join(ErrPreparingQuery, err)
+	}
+	if len(columns) == 0 {
+		log.Error("InsertInto called with a struct without columns", "type", reflect.TypeFor[T]())
+		return nil, errors.Join(ErrPreparingQuery, ErrInvalidType)
+	}
+	placeholders := make([]string, len(columns))
+	for i := range columns {
+		placeholders[i] = placeholder(opts.dialect, i+1)
+	}
+	return NewWithOptions[T]("INSERT INTO "+table+" ("+strings.Join(columns, ", ")+") VALUES ("+strings.Join(placeholders, ", ")+")", maybeOptions...)
+}
+
+// ExecStruct executes a statement prepared from InsertInto with the values of the row, see ExecStructContext
+//
+// Parameters:
+//   - stmt: The statement prepared from InsertInto
+//   - row: The row to insert
+//
+// Returns:
+//   - sql.Result: The result of the statement execution
+//   - error: If the statement is nil or the execution fails
+func ExecStruct[T any](stmt *QueryStmt[T], row T) (sql.Result, error) {
+	return ExecStructContext(stmt, context.Background(), row)
+}
+
+// ExecStructContext executes a statement prepared from InsertInto with the values of the row, bound in the order of
+// the columns of InsertInto.
+//
+// Parameters:
+//   - stmt: The statement prepared from InsertInto
+//   - ctx: The context for the statement execution
+//   - row: The row to insert
+//
+// Returns:
+//   - sql.Result: The result of the statement execution
+//   - error: If the statement is nil or the execution fails
+func ExecStructContext[T any](stmt *QueryStmt[T], ctx context.Context, row T) (sql.Result, error) {
+	if stmt == nil {
+		log.ErrorContext(ctx, "ExecStruct called on a nil query")
+		return nil, errors.Join(ErrExecutingQuery, ErrNilQuery)
+	}
+	_, indices, err := structColumns(reflect.TypeFor[T](), stmt.queryOptions().tagNames)
+	if err != nil {
+		return nil, errors.Join(ErrExecutingQuery, err)
+	}
+	rowValue := reflect.ValueOf(row)
+	args := make([]any, len(indices))
+	for i, index := range indices {
+		args[i] = rowValue.FieldByIndex(index).Interface()
+	}
+	return stmt.ExecContext(ctx, args...)
+}
+
+// BulkInsertMaps inserts rows whose columns are only known at runtime with a single multi-row INSERT statement.
+// The values of each row are bound in the order of cols, a column missing from a row is inserted as NULL.
+//
+// Example usage:
+//
+//	result, err := BulkInsertMaps(ctx, db, "User", []string{"id", "name"}, []map[string]any{
+//	    {"id": 1, "name": "John Doe"},
+//	    {"id": 2},
+//	})
+//
+// Parameters:
+//   - ctx: The context for the statement preparation and execution
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - table: The table to insert into, must be a valid identifier
+//   - cols: The columns to insert, must be valid identifiers
+//   - rows: The rows to insert keyed by column
+//   - maybeOptions: Optional options such as WithDialect
+//
+// Returns:
+//   - sql.Result: The result of the statement execution
+//   - error: If the table or a column is not a valid identifier, there is nothing to insert or execution fails
+func BulkInsertMaps(ctx context.Context, db Preparer, table string, cols []string, rows []map[string]any, maybeOptions ...Option) (sql.Result, error) {
+	if isNil(db) {
+		log.ErrorContext(ctx, "BulkInsertMaps called with a nil tx or db")
+		return nil, errors.Join(ErrExecutingQuery, ErrPreparingQuery)
+	}
+	if len(cols) == 0 || len(rows) == 0 {
+		log.ErrorContext(ctx, "BulkInsertMaps called without columns or rows", "columns", len(cols), "rows", len(rows))
+		return nil, errors.Join(ErrExecutingQuery, errors.New("columns and rows are required"))
+	}
+	for _, name := range append([]string{table}, cols...) {
+		if _, err := Ident(name); err != nil {
+			return nil, errors.Join(ErrExecutingQuery, err)
+		}
+	}
+	opts := newOptions(maybeOptions...)
+	values := make([]string, len(rows))
+	args := make([]any, 0, len(rows)*len(cols))
+	for i, row := range rows {
+		placeholders := make([]string, len(cols))
+		for j, col := range cols {
+			placeholders[j] = placeholder(opts.dialect, len(args)+1)
+			// a missing key is a nil value which is bound as NULL
+			args = append(args, row[col])
+		}
+		values[i] = "(" + strings.Join(placeholders, ", ") + ")"
+	}
+	stmt, err := db.PrepareContext(ctx, "INSERT INTO "+table+" ("+strings.Join(cols, ", ")+") VALUES "+strings.Join(values, ", "))
+	if err != nil {
+		log.ErrorContext(ctx, "failed to prepare query", "error", err)
+		return nil, errors.Join(ErrExecutingQuery, err)
+	}
+	defer stmt.Close()
+	result, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		return nil, errors.Join(ErrExecutingQuery, err)
+	}
+	return result, nil
+}
+
+// DeleteByPK deletes the row of T with the given primary key.
+// The table is the name of T and the primary key columns are the fields tagged with the pk flag,
+// e.g. `tql:"id,pk"`, or the id column if no field is tagged. WithKey sets other key columns.
+// A composite primary key is bound from a []any holding the values in field order or from a T holding the key fields.
+//
+// Example usage:
+//
+//	result, err := DeleteByPK[User](ctx, db, 1)
+//	result, err := DeleteByPK[Membership](ctx, db, []any{userId, accountId})
+//
+// Parameters:
+//   - ctx: The context for the statement preparation and execution
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - key: The primary key value, a []any or a T for a composite primary key
+//   - maybeOptions: Optional options such as WithKey, WithDialect or WithTagNames
+//
+// Returns:
+//   - sql.Result: The result of the statement execution
+//   - error: If T has no primary key, the key doesn't match the primary key columns or execution fails
+func DeleteByPK[T any, Q Preparer](ctx context.Context, db Q, key any, maybeOptions ...Option) (sql.Result, error) {
+	if isNil(db) {
+		log.ErrorContext(ctx, "DeleteByPK called with a nil tx or db")
+		return nil, errors.Join(ErrExecutingQuery, ErrPreparingQuery)
+	}
+	opts := newOptions(maybeOptions...)
+	table := reflect.TypeFor[T]()
+	columns, indices, err := primaryKeyColumns(table, opts.tagNames, opts.keyColumns)
+	if err != nil {
+		return nil, errors.Join(ErrExecutingQuery, err)
+	}
+	var args []any
+	switch key := key.(type) {
+	case T:
+		row := reflect.ValueOf(key)
+		for _, index := range indices {
+			args = append(args, row.FieldByIndex(index).Interface())
+		}
+	case []any:
+		args = key
+	default:
+		args = []any{key}
+	}
+	if len(args) != len(columns) {
+		log.ErrorContext(ctx, "the key doesn't match the primary key", "columns", columns, "key", key)
+		return nil, errors.Join(ErrExecutingQuery, fmt.Errorf("expected %d primary key values for [%s], got %d", len(columns), strings.Join(columns, ", "), len(args)))
+	}
+	conditions := make([]string, len(columns))
+	for i, column := range columns {
+		conditions[i] = column + " = " + placeholder(opts.dialect, i+1)
+	}
+	stmt, err := db.PrepareContext(ctx, "DELETE FROM "+table.Name()+" WHERE "+strings.Join(conditions, " AND "))
+	if err != nil {
+		log.ErrorContext(ctx, "failed to prepare query", "error", err)
+		return nil, errors.Join(ErrExecutingQuery, err)
+	}
+	defer stmt.Close()
+	result, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		return nil, errors.Join(ErrExecutingQuery, err)
+	}
+	return result, nil
+}
+
+// UpdateByPK updates the row of T with the primary key of the given row, setting its other columns to the values of
+// the row. The table and the primary key columns are the ones of DeleteByPK. WithColumns only sets the given columns,
+// e.g. for a partial update, otherwise every column that is not part of the primary key is set.
+//
+// Example usage:
+//
+//	result, err := UpdateByPK(ctx, db, user)
+//	result, err := UpdateByPK(ctx, db, user, WithColumns("name"))
+//	result, err := UpdateByPK(ctx, db, user, WithKey("uuid"), WithDialect(DialectPostgres))
+//
+// Parameters:
+//   - ctx: The context for the statement preparation and execution
+//   - db: Database connection, can be any Preparer such as *sql.DB or *sql.Tx
+//   - row: The row holding the primary key and the values to set
+//   - maybeOptions: Optional options such as WithColumns, WithKey, WithDialect or WithTagNames
+//
+// Returns:
+//   - sql.Result: The result of the statement execution
+//   - error: If T has no primary key, a column is not a column of T, is part of the primary key or is set twice or
+//     execution fails
+func UpdateByPK[T any, Q Preparer](ctx context.Context, db Q, row T, maybeOptions ...Option) (sql.Result, error) {
+	if isNil(db) {
+		log.ErrorContext(ctx, "UpdateByPK called with a nil tx or db")
+		return nil, errors.Join(ErrExecutingQuery, ErrPreparingQuery)
+	}
+	opts := newOptions(maybeOptions...)
+	table := reflect.TypeFor[T]()
+	pkColumns, pkIndices, err := primaryKeyColumns(table, opts.tagNames, opts.keyColumns)
+	if err != nil {
+		return nil, errors.Join(ErrExecutingQuery, err)
+	}
+	allColumns, allIndices, err := structColumns(table, opts.tagNames)
+	if err != nil {
+		return nil, errors.Join(ErrExecutingQuery, err)
+	}
+	// the primary key of the struct is never set, even when WithKey identifies the row by other columns
+	fixedColumns := pkColumns
+	if len(opts.keyColumns) > 0 {
+		if primaryKey, _, err := primaryKeyColumns(table, opts.tagNames, nil); err == nil {
+			fixedColumns = append(slices.Clone(pkColumns), primaryKey...)
+		}
+	}
+	columns := opts.updateColumns
+	if len(columns) == 0 {
+		for _, column := range allColumns {
+			if !slices.Contains(fixedColumns, column) {
+				columns = append(columns, column)
+			}
+		}
+	}
+	if len(columns) == 0 {
+		log.ErrorContext(ctx, "no columns to update", "table", table.Name())
+		return nil, errors.Join(ErrExecutingQuery, errors.New(table.Name()+" has no columns besides the primary key"))
+	}
+	rowValue := reflect.ValueOf(row)
+	assignments := make([]string, len(columns))
+	args := make([]any, 0, len(columns)+len(pkColumns))
+	for i, column := range columns {
+		position := slices.Index(allColumns, column)
+		if position < 0 || slices.Contains(fixedColumns, column) {
+			log.ErrorContext(ctx, "the column can't be updated", "table", table.Name(), "column", column)
+			return nil, errors.Join(ErrExecutingQuery, fmt.Errorf("%w: %s is not a column of %s or is part of its primary key", ErrInvalidArgument, column, table.Name()))
+		}
+		if slices.Contains(columns[:i], column) {
+			log.ErrorContext(ctx, "the column is set twice", "table", table.Name(), "column", column)
+			return nil, errors.Join(ErrExecutingQuery, fmt.Errorf("%w: %s is set more than once", ErrInvalidArgument, column))
+		}
+		// the placeholders of the assignments come first, then the ones of the primary key
+		assignments[i] = column + " = " + placeholder(opts.dialect, len(args)+1)
+		args = append(args, rowValue.FieldByIndex(allIndices[position]).Interface())
+	}
+	conditions := make([]string, len(pkColumns))
+	for i, column := range pkColumns {
+		conditions[i] = column + " = " + placeholder(opts.dialect, len(args)+1)
+		args = append(args, rowValue.FieldByIndex(pkIndices[i]).Interface())
+	}
+	stmt, err := db.PrepareContext(ctx, "UPDATE "+table.Name()+" SET "+strings.Join(assignments, ", ")+" WHERE "+strings.Join(conditions, " AND "))
+	if err != nil {
+		log.ErrorContext(ctx, "failed to prepare query", "error", err)
+		return nil, errors.Join(ErrExecutingQuery, err)
+	}
+	defer stmt.Close()
+	result, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		return nil, errors.Join(ErrExecutingQuery, err)
+	}
+	return result, nil
+}
+
+// primaryKeyColumns returns the primary key columns and field indices of a single table struct, which are the key
+// columns if any, the fields tagged with the pk flag or the id column if no field is tagged
+//
+// Parameters:
+//   - table: The reflected type of the struct
+//   - tagNames: The struct tags to read the column names from
+//   - key: The key columns set with WithKey, nil for the primary key of the struct
+//
+// Returns:
+//   - []string: The primary key column names
+//   - [][]int: The indices of the primary key fields
+//   - error: If the type is not a named struct, it has no primary key, a key column is not a column of the struct or
+//     a column is not a valid identifier
+func primaryKeyColumns(table reflect.Type, tagNames []string, key []string) ([]string, [][]int, error) {
+	columns, indices, err := structColumns(table, tagNames)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := Ident(table.Name()); err != nil {
+		return nil, nil, err
+	}
+	pkColumns := []string{}
+	pkIndices := [][]int{}
+	for _, column := range key {
+		position := slices.Index(columns, column)
+		if position < 0 {
+			log.Error("the key column is not a column of the table", "table", table.Name(), "column", column)
+			return nil, nil, fmt.Errorf("%w: key column %s is not a column of %s", ErrInvalidArgument, column, table.Name())
+		}
+		pkColumns = append(pkColumns, column)
+		pkIndices = append(pkIndices, indices[position])
+	}
+	if len(pkColumns) > 0 {
+		return pkColumns, pkIndices, nil
+	}
+	idIndex := -1
+	for i, index := range indices {
+		if parseTQLTag(table.FieldByIndex(index), tagNames).pk {
+			pkColumns = append(pkColumns, columns[i])
+			pkIndices = append(pkIndices, index)
+		}
+		if columns[i] == "id" {
+			idIndex = i
+		}
+	}
+	if len(pkColumns) > 0 {
+		return pkColumns, pkIndices, nil
+	}
+	if idIndex < 0 {
+		log.Error("no primary key", "table", table.Name())
+		return nil, nil, errors.Join(ErrInvalidType, errors.New(table.Name()+" has no field tagged with the pk flag or id column"))
+	}
+	return columns[idIndex : idIndex+1], indices[idIndex : idIndex+1], nil
+}
+
+// structColumns returns the column names and field index paths of the tagged fields of a single table struct.
+// The fields of embedded and nested structs are columns of the table, see columnFields.
+//
+// Parameters:
+//   - table: The reflected type of the struct
+//   - tagNames: The struct tags to read the column names from
+//
+// Returns:
+//   - []string: The column names
+//   - [][]int: The index paths of the fields
+//   - error: If the type is not a struct, two fields are ambiguous or a column is not a valid identifier
+func structColumns(table reflect.Type, tagNames []string) ([]string, [][]int, error) {
+	if table.Kind() != reflect.Struct {
+		log.Error("a struct is required", "received", table)
+		return nil, nil, ErrInvalidType
+	}
+	fields, err := columnFields(table, tagNames)
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := []string{}
+	indices := [][]int{}
+	for _, field := range fields {
+		fieldTag := parseTQLTag(field, tagNames)
+		// a computed column can't be written and the rest field only receives the columns that are not mapped
+		if fieldTag.omit == "true" || fieldTag.expr || fieldTag.rest || !field.IsExported() {
+			continue
+		}
+		if _, err := Ident(fieldTag.field); err != nil {
+			return nil, nil, err
+		}
+		columns = append(columns, fieldTag.field)
+		indices = append(indices, field.Index)
+	}
+	return columns, indices, nil
+}
+
+// placeholder returns the placeholder of the argument at the ordinal for the dialect
+//
+// Parameters:
+//   - dialect: The dialect of the placeholders
+//   - ordinal: The 1-based position of the argument
+//
+// Returns:
+//   - string: ? for MySQL, $ordinal for Postgres
+func placeholder(dialect Dialect, ordinal int) string {
+	if dialect == DialectPostgres {
+		return "$" + strconv.Itoa(ordinal)
+	}
+	return "?"
+}